@@ -6,10 +6,19 @@
 package alertscript
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jaw0/go-alertscript/module"
+	"github.com/jaw0/go-alertscript/module/std/filestore"
+	"github.com/jaw0/go-alertscript/module/std/memstore"
 	"github.com/dop251/goja"
 
 	_ "github.com/jaw0/go-alertscript/module/ext/mailchimp"
@@ -28,29 +37,78 @@ type logger interface {
 }
 
 type Conf struct {
-	Script      string
-	DataName    string
-	Data        interface{}
-	Timeout     time.Duration
-	NetTimeout  time.Duration
-	HardTimeout time.Duration
-	NetMax      int
-	NetMock     bool
-	Init        func(*goja.Runtime)
-	Logger      logger
-	Trace       string
-	Federation  string
-	DataDir     string
+	Script           string
+	DataName         string
+	Data             interface{}
+	Timeout          time.Duration
+	NetTimeout       time.Duration
+	HardTimeout      time.Duration
+	NetMax           int
+	NetMock          bool
+	Init             func(*goja.Runtime)
+	Logger           logger
+	Trace            string
+	Federation       string
+	DataDir          string            // libraries in DataDir/lib, the default store in DataDir/store
+	Libraries        map[string]string // name => source, for module('lib/name')
+	Pool             *Pool             // optional, reuse runtimes
+	MaxHeapGrowth    int64             // bytes. process-wide guard, not a per-script quota, see limits.go. 0 = none
+	MaxCallStackSize int               // default 10000
+	AllowModules     []string          // glob patterns, eg. "std/*". empty = all
+	DenyModules      []string          // glob patterns, checked first
+	NetPolicy        *NetPolicy        // where scripts may connect. nil = anywhere
+	Cassette         *Cassette         // record, or replay, network requests
+	Mocks            Mocks             // simulated responses, when NetMock
+	MaxResponseSize  int64             // bytes, default 10MB
+	Retry            *RetryPolicy      // default for http requests. nil = no retries
+	TLSProfiles      TLSProfiles       // name => client tls config, selected by the script
+	SigningKeys      SigningKeys       // name => key for signing requests, selected by the script
+	OAuth2           OAuth2Clients     // name => oauth2 client, selected by the script
+	TokenCache       *TokenCache       // oauth2 tokens, shared across runs. nil = cache for this run only
+	Store            Store             // for std/store. default is files in DataDir, else in memory for this run only
+	DKIM             DKIMKeys          // sender domain => key, mail sent by std/smtp is signed
 }
 
+type NetPolicy = module.NetPolicy
+type Cassette = module.Cassette
+type Mocks = module.Mocks
+type RetryPolicy = module.RetryPolicy
+type TLSProfile = module.TLSProfile
+type TLSProfiles = module.TLSProfiles
+type SigningKey = module.SigningKey
+type SigningKeys = module.SigningKeys
+type OAuth2Client = module.OAuth2Client
+type OAuth2Clients = module.OAuth2Clients
+type DKIMKey = module.DKIMKey
+type DKIMKeys = module.DKIMKeys
+type TokenCache = module.TokenCache
+type Store = module.Store
+
 type AS struct {
 	cf        *Conf
 	vm        *goja.Runtime
+	ctx       context.Context
 	t0        time.Time
 	t1        time.Time
 	tacc      time.Duration
 	timer     *time.Timer
+	lock      sync.Mutex
+	done      bool
+	inflight  int
+	intr      interface{}
+	reason    string
+	tend      time.Time
+	calls     []*module.Call
+	report    *Report
+	stop      chan struct{} // closed when interrupted
+	quit      chan struct{} // closed when finished
+	loop      eventLoop
+	libs      map[string]*library
+	libStack  []string
+	tokens    *module.TokenCache
+	store     module.Store
 	Result    goja.Value
+	result    json.RawMessage // exported, for the report
 	NetReqs   int
 	LocalReqs int
 	NetErrs   int
@@ -62,9 +120,11 @@ type mAS struct {
 }
 
 const (
-	defaultTimeout    = 2 * time.Second
-	defaultWebTimeout = 1 * time.Second
-	defaultHard       = 30 * time.Second
+	defaultTimeout     = 2 * time.Second
+	defaultWebTimeout  = 1 * time.Second
+	defaultHard        = 30 * time.Second
+	defaultMaxResponse = 10 << 20
+	storeDir           = "store"
 )
 
 const (
@@ -72,11 +132,31 @@ const (
 	CTL_RESUME = 1
 )
 
+// compile (or fetch from cache) and run the script in cf.Script
 func Run(cf *Conf) (*AS, error) {
+	return RunContext(context.Background(), cf)
+}
 
-	vm := goja.New()
-	as := &AS{cf: cf, vm: vm}
-	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+// as Run, cancelling the context aborts the script and any network requests in progress
+func RunContext(ctx context.Context, cf *Conf) (*AS, error) {
+
+	s, err := Compile(cf.Script)
+	if err != nil {
+		if cf.Logger != nil {
+			cf.Logger.Error(err)
+		}
+		return nil, err
+	}
+
+	return s.RunContext(ctx, cf)
+}
+
+// run a previously compiled script. cf.Script is ignored
+func (s *Script) Run(cf *Conf) (*AS, error) {
+	return s.RunContext(context.Background(), cf)
+}
+
+func (s *Script) RunContext(ctx context.Context, cf *Conf) (*AS, error) {
 
 	if cf.NetTimeout == 0 {
 		cf.NetTimeout = defaultWebTimeout
@@ -87,30 +167,42 @@ func Run(cf *Conf) (*AS, error) {
 	if cf.HardTimeout == 0 {
 		cf.HardTimeout = defaultHard
 	}
-
-	// wire up console.log output
-	logger := func(c goja.FunctionCall) goja.Value {
-		as.Log(joinJsArgs(c))
-		return nil
+	if cf.MaxCallStackSize == 0 {
+		cf.MaxCallStackSize = defaultMaxStack
 	}
-	debugger := func(c goja.FunctionCall) goja.Value {
-		as.Diag(joinJsArgs(c))
-		return nil
+	if cf.MaxResponseSize == 0 {
+		cf.MaxResponseSize = defaultMaxResponse
 	}
-	erroror := func(c goja.FunctionCall) goja.Value {
-		cf.Logger.Error(fmt.Errorf("%s", joinJsArgs(c)))
-		return nil
+
+	rt := cf.Pool.get(cf.Federation)
+	rt.dirty = s.lexical
+	defer cf.Pool.put(rt)
+
+	as := newAS(cf, rt.vm)
+	rt.m.as = as
+
+	err := as.run(ctx, rt, s)
+	as.finish()
+	as.report = as.buildReport(s, err)
+
+	if err != nil {
+		as.logError(err)
 	}
 
-	vm.Set("console", map[string]interface{}{
-		"log":   logger,
-		"warn":  logger,
-		"error": erroror,
-		"debug": debugger,
-	})
+	return as, err
+}
+
+func (as *AS) run(ctx context.Context, rt *jsRuntime, s *Script) error {
+
+	cf := as.cf
+	vm := as.vm
 
 	// provide useful functions and data
-	module.InstallModule(mAS{as}, vm)
+	err := rt.install()
+	if err != nil {
+		return err
+	}
+
 	if cf.Init != nil {
 		cf.Init(vm)
 	}
@@ -121,30 +213,124 @@ func Run(cf *Conf) (*AS, error) {
 		vm.Set(cf.DataName, cf.Data)
 	}
 
-	// run common runtime code to set up more functions and data
-	_, err := vm.RunProgram(scriptRuntime)
-	if err != nil {
-		cf.Logger.Error(err)
-		return nil, err
-	}
+	vm.SetMaxCallStackSize(cf.MaxCallStackSize)
 
 	// enforce maximum runtime
 	as.t0 = time.Now()
 	as.t1 = as.t0
 	as.timer = time.AfterFunc(cf.Timeout, func() {
-		vm.Interrupt("timeout - maximum runtime exceeded!")
+		// while waiting on the network, the timer is set to the hard limit
+		as.lock.Lock()
+		hard := as.inflight > 0
+		as.lock.Unlock()
+
+		if hard {
+			as.interrupt(TermHardTimeout, &TimeoutError{Hard: true, Limit: cf.HardTimeout})
+		} else {
+			as.interrupt(TermTimeout, &TimeoutError{Limit: cf.Timeout})
+		}
 	})
 	defer as.timer.Stop()
 
-	// run the script
-	res, err := vm.RunString(cf.Script)
+	// modules use the context for their network requests,
+	// it is cancelled when the script finishes or hits the hard limit
+	rctx, cancel := context.WithTimeout(ctx, cf.HardTimeout)
+	defer cancel()
+	as.ctx = rctx
+
+	// stop the script if the caller cancels
+	done := make(chan struct{})
+	defer close(done)
+	go as.watchMemory(done)
+	go func() {
+		select {
+		case <-rctx.Done():
+			if ctx.Err() != nil {
+				as.interrupt(TermCancelled, ctx.Err())
+			} else {
+				as.interrupt(TermHardTimeout, &TimeoutError{Hard: true, Limit: cf.HardTimeout})
+			}
+		case <-done:
+		}
+	}()
+
+	// run the script, then any timers or async requests it started
+	vm.ClearInterrupt()
+	res, err := vm.RunProgram(s.prog)
+	if err == nil {
+		err = as.runLoop()
+	}
+	if err == nil {
+		res, err = as.settle(res)
+	}
 	as.Result = res
+	if err == nil {
+		var xerr error
+		as.result, xerr = as.exportResult(rt.stringify, res)
+		if xerr != nil {
+			as.Diagf("result not exported: %v", xerr)
+		}
+	}
+	as.tend = time.Now()
 
-	if err != nil {
-		cf.Logger.Error(err)
+	return scriptError(err, cf.MaxCallStackSize)
+}
+
+// a fresh js runtime with module() installed
+func newJsRuntime() *jsRuntime {
+
+	vm := goja.New()
+	rt := &jsRuntime{vm: vm, m: &mAS{}}
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	module.InstallModule(rt.m, vm)
+	// before any script can replace it
+	rt.stringify, _ = goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("stringify"))
+
+	rt.snapshot()
+	return rt
+}
+
+// install console and the common runtime for each run
+func (rt *jsRuntime) install() error {
+
+	vm := rt.vm
+
+	// wire up console.log output
+	logger := func(c goja.FunctionCall) goja.Value {
+		rt.m.as.Log(joinJsArgs(c))
+		return nil
+	}
+	debugger := func(c goja.FunctionCall) goja.Value {
+		rt.m.as.Diag(joinJsArgs(c))
+		return nil
+	}
+	erroror := func(c goja.FunctionCall) goja.Value {
+		rt.m.as.logError(fmt.Errorf("%s", joinJsArgs(c)))
+		return nil
 	}
 
-	return as, err
+	vm.Set("console", map[string]interface{}{
+		"log":   logger,
+		"warn":  logger,
+		"error": erroror,
+		"debug": debugger,
+	})
+
+	vm.Set("setTimeout", func(c goja.FunctionCall) goja.Value { return rt.m.as.setTimer(c, false) })
+	vm.Set("setInterval", func(c goja.FunctionCall) goja.Value { return rt.m.as.setTimer(c, true) })
+	vm.Set("clearTimeout", func(c goja.FunctionCall) goja.Value { return rt.m.as.clearTimer(c) })
+	vm.Set("clearInterval", func(c goja.FunctionCall) goja.Value { return rt.m.as.clearTimer(c) })
+
+	// web is available without module(), if permitted
+	if rt.m.CheckModule(webModule) != nil {
+		vm.Set("web", goja.Undefined())
+		return nil
+	}
+
+	// run common runtime code to set up more functions and data
+	_, err := vm.RunProgram(scriptRuntime)
+	return err
 }
 
 // rom console.log (et al)
@@ -157,6 +343,12 @@ func (as *AS) Logf(s string, args ...interface{}) {
 	}
 }
 
+func (as *AS) logError(err error) {
+	if as.cf.Logger != nil {
+		as.cf.Logger.Error(err)
+	}
+}
+
 // from internals
 func (as *AS) Diag(s string) {
 	as.Diagf("%s", s)
@@ -180,6 +372,88 @@ func joinJsArgs(c goja.FunctionCall) string {
 	return out
 }
 
+func newAS(cf *Conf, vm *goja.Runtime) *AS {
+	as := &AS{
+		cf:     cf,
+		vm:     vm,
+		libs:   make(map[string]*library),
+		stop:   make(chan struct{}),
+		quit:   make(chan struct{}),
+		tokens: cf.TokenCache,
+		store:  cf.Store,
+		loop: eventLoop{
+			jobs:   make(chan func() error),
+			timers: make(map[int64]*jsTimer),
+		},
+	}
+	if as.tokens == nil {
+		as.tokens = module.NewTokenCache()
+	}
+	if as.store == nil {
+		if cf.DataDir != "" {
+			as.store = filestore.New(filepath.Join(cf.DataDir, storeDir))
+		} else {
+			as.store = memstore.New()
+		}
+	}
+	return as
+}
+
+// interrupt the script, unless it has already finished
+// (the runtime may be running someone else's script by now)
+func (as *AS) interrupt(reason string, v interface{}) {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+
+	if as.done {
+		return
+	}
+	as.vm.Interrupt(v)
+
+	if as.intr == nil {
+		// wake up the event loop
+		as.intr = v
+		as.reason = reason
+		close(as.stop)
+	}
+}
+
+// stop everything, and wait for any background requests to finish
+func (as *AS) finish() {
+	as.lock.Lock()
+	as.done = true
+	as.lock.Unlock()
+
+	close(as.quit)
+	as.loop.stopTimers()
+	as.loop.wg.Wait()
+}
+
+// the runtime clock is paused while any network requests are in progress
+func (as *AS) ioStart() func() {
+	as.lock.Lock()
+	if as.inflight == 0 {
+		as.pauseTimer()
+	}
+	as.inflight++
+	as.lock.Unlock()
+
+	t0 := time.Now() // start timing
+
+	return func() {
+		// stop timing
+		dur := time.Now().Sub(t0)
+
+		as.lock.Lock()
+		defer as.lock.Unlock()
+		as.NetTime += dur
+		as.inflight--
+		if as.inflight == 0 {
+			as.resumeTimer()
+		}
+	}
+}
+
 func (as *AS) pauseTimer() {
 	// update the state, move the timer to the hard limit
 	t := time.Now()
@@ -210,53 +484,45 @@ func (m mAS) Diagf(s string, args ...interface{}) {
 }
 
 // for ordinary network requests
+// may be called concurrently, from async requests
 func (m mAS) NetIOHeavy() (func(), error) {
+	m.as.lock.Lock()
 	m.as.NetReqs++
+	over := m.as.NetReqs > m.as.cf.NetMax
+	m.as.lock.Unlock()
 
-	if m.as.NetReqs > m.as.cf.NetMax {
-		err := fmt.Errorf("Maximum number of web requests exceeded!")
-		m.as.vm.Interrupt(err)
+	if over {
+		err := &QuotaError{Limit: m.as.cf.NetMax}
+		m.as.interrupt(TermQuota, err)
 		return nil, err
 	}
 
-	m.as.pauseTimer()
-	t0 := time.Now() // start timing
-
-	return func() {
-		// stop timing
-		dur := time.Now().Sub(t0)
-		m.as.NetTime += dur
-		m.as.resumeTimer()
-	}, nil
+	return m.as.ioStart(), nil
 }
 
 // for local (on-net) network requests
 func (m mAS) NetIOLight() (func(), error) {
+	m.as.lock.Lock()
 	m.as.LocalReqs++
+	m.as.lock.Unlock()
 
-	m.as.pauseTimer()
-	t0 := time.Now() // start timing
-
-	return func() {
-		// stop timing
-		dur := time.Now().Sub(t0)
-		m.as.NetTime += dur
-		m.as.resumeTimer()
-	}, nil
+	return m.as.ioStart(), nil
 }
 
 func (m mAS) NetIOErr() {
+	m.as.lock.Lock()
+	defer m.as.lock.Unlock()
 	m.as.NetErrs++
 }
 
 func (m mAS) Fatal(err error) {
-	m.as.NetErrs++
-	m.as.cf.Logger.Error(err)
-	m.as.vm.Interrupt(err)
+	m.NetIOErr()
+	m.as.logError(err)
+	m.as.interrupt(TermError, err)
 }
 func (m mAS) Error(err error) {
-	m.as.NetErrs++
-	m.as.cf.Logger.Error(err)
+	m.NetIOErr()
+	m.as.logError(err)
 }
 
 func (m mAS) IsDryRun() bool {
@@ -279,8 +545,127 @@ func (m mAS) Federation() string {
 	return m.as.cf.Federation
 }
 
+// network responses larger than this are truncated
+func (m mAS) MaxResponseSize() int64 {
+	return m.as.cf.MaxResponseSize
+}
+
+func (m mAS) Context() context.Context {
+	return m.as.ctx
+}
+
+func (m mAS) NetPolicy() *module.NetPolicy {
+	return m.as.cf.NetPolicy
+}
+
+func (m mAS) Cassette() *module.Cassette {
+	return m.as.cf.Cassette
+}
+
+// for http based modules. obeys the NetPolicy, records or replays.
+// the transport applies NetTimeout to each attempt, so retries are not cut short
+func (m mAS) HTTPClient() *http.Client {
+	c := m.as.cf.NetPolicy.HTTPClient(0)
+	c.Transport = m.Transport()
+	return c
+}
+
+func (m mAS) Transport() http.RoundTripper {
+	return m.wrapTransport(m.as.cf.NetPolicy.Transport())
+}
+
+func (m mAS) SigningKey(name string) (*module.SigningKey, error) {
+	return m.as.cf.SigningKeys.Get(name)
+}
+
+func (m mAS) OAuth2Client(name string) (*module.OAuth2Client, error) {
+	return m.as.cf.OAuth2.Get(name)
+}
+
+func (m mAS) DKIMKey(domain string) *module.DKIMKey {
+	return m.as.cf.DKIM.Get(domain)
+}
+
+func (m mAS) TokenCache() *module.TokenCache {
+	return m.as.tokens
+}
+
+func (m mAS) Store() module.Store {
+	return m.as.store
+}
+
+// an http client using the named tls profile
+func (m mAS) TLSClient(name string) (*http.Client, error) {
+
+	tp, err := m.as.cf.TLSProfiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := tp.Transport(m.as.cf.NetPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("tls profile '%s': %v", name, err)
+	}
+
+	c := m.as.cf.NetPolicy.HTTPClient(0)
+	c.Transport = m.wrapTransport(rt)
+	return c, nil
+}
+
+func (m mAS) wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	cf := m.as.cf
+	return cf.Retry.Transport(m, cf.Cassette.Transport(rt, cf.MaxResponseSize))
+}
+
+// a simulated response for the call, on a dry run
+func (m mAS) Mock(c *module.Call) *module.Mock {
+
+	if !m.as.cf.NetMock {
+		return nil
+	}
+	mk := m.as.cf.Mocks.Find(c)
+	if mk == nil || mk.Latency <= 0 {
+		return mk
+	}
+
+	t := time.NewTimer(time.Duration(mk.Latency))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-m.as.ctx.Done():
+	}
+	return mk
+}
+
+// may the script use the module? libraries are always permitted
+func (m mAS) CheckModule(name string) error {
+	cf := m.as.cf
+
+	if strings.HasPrefix(name, module.LibPrefix) {
+		return nil
+	}
+	if matchAny(name, cf.DenyModules) {
+		return &ModuleForbiddenError{Name: name}
+	}
+	if len(cf.AllowModules) != 0 && !matchAny(name, cf.AllowModules) {
+		return &ModuleForbiddenError{Name: name}
+	}
+	return nil
+}
+
+// invalid patterns never match
+func matchAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // ################################################################
 
+const webModule = "std/web"
+
 var scriptRuntime = goja.MustCompile("runtime", `
 var web = module('std/web')
 `, false)
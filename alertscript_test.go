@@ -0,0 +1,1710 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 11:52 (EDT)
+// Function: tests + benchmarks
+
+package alertscript
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/jaw0/go-alertscript/module/std/memstore"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Verbose(s string, p ...interface{}) { l.t.Logf(s, p...) }
+func (l testLogger) Debug(s string, p ...interface{})   { l.t.Logf("[diag]> "+s, p...) }
+func (l testLogger) Error(err error)                    { l.t.Logf("[error]> %v", err) }
+
+const benchScript = `
+var n = 0
+for (var i=0; i<10; i++){ n += i }
+if( event.type == 'yes' ){ n++ }
+n
+`
+
+type benchEvent struct {
+	Type string `json:"type"`
+}
+
+func runPool(t testing.TB, p *Pool, fed, script string) *AS {
+	as, err := Run(&Conf{
+		Script:     script,
+		Logger:     testLogger{t},
+		Federation: fed,
+		Pool:       p,
+		NetMax:     2,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	return as
+}
+
+func TestPool(t *testing.T) {
+	p := NewPool(2)
+
+	runPool(t, p, "a", `var leak = 'secret'; implicit = 1; web = null; console.log = null`)
+	if p.Len() != 1 {
+		t.Fatalf("runtime not returned to pool")
+	}
+
+	as := runPool(t, p, "a", `typeof leak + ' ' + typeof implicit + ' ' + typeof web + ' ' + typeof console.log`)
+	if r := as.Result.String(); r != "undefined undefined object function" {
+		t.Fatalf("globals leaked: %s", r)
+	}
+
+	// clobbering Object does not break the reset
+	runPool(t, p, "a", `var leak2 = 1; Object = null`)
+	runPool(t, p, "a", `Object.getOwnPropertyNames = function(){ return [] }; var leak3 = 1`)
+	as = runPool(t, p, "a", `typeof leak2 + ' ' + typeof leak3 + ' ' + typeof Object`)
+	if r := as.Result.String(); r != "undefined undefined function" {
+		t.Fatalf("globals leaked: %s", r)
+	}
+
+	runPool(t, p, "b", `1`)
+	if p.Len() != 2 {
+		t.Fatalf("expected a runtime per federation, have %d", p.Len())
+	}
+
+	// an interrupted runtime is still usable
+	_, err := Run(&Conf{
+		Script:     `while(1){}`,
+		Logger:     testLogger{t},
+		Federation: "a",
+		Pool:       p,
+		Timeout:    10 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatalf("expected timeout")
+	}
+
+	as = runPool(t, p, "a", `2 + 2`)
+	if as.Result.ToInteger() != 4 {
+		t.Fatalf("result: %v", as.Result)
+	}
+
+	// top level let cannot be undone
+	for i := 0; i < 2; i++ {
+		runPool(t, p, "a", `let x = 1; const y = 2; x + y`)
+	}
+}
+
+func TestCompile(t *testing.T) {
+
+	src := `var n = 0; for (var i=0; i<event; i++){ n += i }; n`
+	s, err := Compile(src)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	h := sha256.Sum256([]byte(src))
+	if s.Hash() != hex.EncodeToString(h[:]) {
+		t.Fatalf("hash: %s", s.Hash())
+	}
+	if s2, _ := Compile(src); s2 != s {
+		t.Fatalf("not cached")
+	}
+
+	// a compiled script can be run many times, concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			as, err := s.Run(&Conf{Logger: testLogger{t}, DataName: "event", Data: i})
+			if err != nil {
+				t.Errorf("error: %v", err)
+				return
+			}
+			if r := as.Result.ToInteger(); r != int64(i*(i-1)/2) {
+				t.Errorf("%d => %d", i, r)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var cerr *CompileError
+	if _, err := Compile("var = 1"); !errors.As(err, &cerr) {
+		t.Fatalf("expected compile error: %v", err)
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	defer SetCacheSize(defaultCacheSize)
+
+	compile := func(src string) *Script {
+		s, err := Compile(src)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return s
+	}
+
+	SetCacheSize(2)
+	if scriptCache.lru.Len() > 2 {
+		t.Fatalf("resize did not trim: %d", scriptCache.lru.Len())
+	}
+
+	a := compile(`'cache a'`)
+	b := compile(`'cache b'`)
+	// a is now more recently used than b
+	if compile(`'cache a'`) != a {
+		t.Fatalf("a not cached")
+	}
+	compile(`'cache c'`)
+
+	if compile(`'cache a'`) != a {
+		t.Fatalf("a evicted")
+	}
+	if compile(`'cache b'`) == b {
+		t.Fatalf("b not evicted")
+	}
+
+	SetCacheSize(1)
+	if scriptCache.lru.Len() != 1 {
+		t.Fatalf("resize did not trim: %d", scriptCache.lru.Len())
+	}
+
+	// disabled
+	SetCacheSize(0)
+	if compile(`'cache d'`) == compile(`'cache d'`) || scriptCache.lru.Len() != 0 {
+		t.Fatalf("cached while disabled")
+	}
+}
+
+func benchmarkRun(b *testing.B, p *Pool) {
+	cf := &Conf{
+		Script:   benchScript,
+		Logger:   testLogger{b},
+		DataName: "event",
+		Data:     &benchEvent{"yes"},
+		NetMax:   2,
+		Pool:     p,
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := Run(cf)
+		if err != nil {
+			b.Fatalf("error: %v", err)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	benchmarkRun(b, nil)
+}
+
+func BenchmarkRunPool(b *testing.B) {
+	benchmarkRun(b, NewPool(4))
+}
+
+func TestTimers(t *testing.T) {
+	as, err := Run(&Conf{
+		Script: `
+var out = []
+clearTimeout( setTimeout(function(){ out.push('never') }, 1) )
+;(async function(){
+    // each timer is started by the previous one, so the order does not depend on the scheduler
+    await new Promise(function(resolve){
+        setTimeout(function(){
+            out.push('a')
+            var n = 0
+            var iv = setInterval(function(){
+                if( ++n != 3 ) return
+                clearInterval(iv)
+                out.push('c')
+                setTimeout(function(x){ out.push(x); resolve() }, 5, 'b')
+            }, 5)
+        }, 10)
+    })
+    return out.join(',')
+})()
+`,
+		Logger: testLogger{t},
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != "a,c,b" {
+		t.Fatalf("result: %s", r)
+	}
+}
+
+func TestAsyncWeb(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	script := `
+(async function(){
+    var r = await Promise.all([ web.get_async(url + '/a'), web.get_async(url + '/b'), web.get_async(url + '/c') ])
+    return r.map(function(x){ return x.body }).join(',')
+})()
+`
+	t0 := time.Now()
+	as, err := Run(&Conf{
+		Script:   script,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   3,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != "/a,/b,/c" {
+		t.Fatalf("result: %s", r)
+	}
+	if dt := time.Since(t0); dt > 250*time.Millisecond {
+		t.Fatalf("requests not concurrent: %v", dt)
+	}
+
+	// settings changed after the call do not affect it
+	jsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"a":1}`))
+	}))
+	defer jsrv.Close()
+
+	as, err = Run(&Conf{
+		Script: `
+(async function(){
+    var p = web.get_async(url)
+    web.decode_json = true; web.retry = {attempts: 2}; web.tls = 'none'
+    var r = await p
+    return r.body + ' ' + r.data
+})()`,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     jsrv.URL,
+		NetMax:   1,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != `{"a":1} null` {
+		t.Fatalf("result: %s", r)
+	}
+
+	// the limit still applies
+	_, err = Run(&Conf{
+		Script:   script,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   2,
+	})
+	if err == nil {
+		t.Fatalf("expected too many requests")
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "lib"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "lib", "fmt.js"), []byte(`
+exports.pct = function(x){ return Math.round(x * 100) + '%' }
+`), 0644)
+
+	libs := map[string]string{
+		"util":  `var f = module('lib/fmt'); module.exports = { twice: function(x){ return f.pct(2 * x) } }`,
+		"cyc/a": `module('lib/cyc/b')`,
+		"cyc/b": `module('lib/cyc/a')`,
+		"bad":   `throw new Error('broken')`,
+	}
+
+	run := func(script string) (*AS, error) {
+		return Run(&Conf{
+			Script:    script,
+			Logger:    testLogger{t},
+			Libraries: libs,
+			DataDir:   dir,
+		})
+	}
+
+	as, err := run(`var u = module('lib/util'); u === module('lib/util') && u.twice(0.25)`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != "50%" {
+		t.Fatalf("result: %s", r)
+	}
+
+	as, err = run(`try { module('lib/bad') } catch(e){ e.message }`)
+	if err != nil || as.Result.String() != "broken" {
+		t.Fatalf("exception not propagated: %v", err)
+	}
+
+	if _, err = run(`module('lib/cyc/a')`); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle: %v", err)
+	}
+	if _, err = run(`module('lib/../../etc/passwd')`); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found: %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+
+	as, err := Run(&Conf{
+		Script:   `var r = web.get(url); ({code: r.code})`,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   1,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	r := as.Report()
+	if r.Termination != TermOK || string(r.Result) != `{"code":503}` {
+		t.Fatalf("report: %+v", r)
+	}
+	if len(r.Calls) != 1 || r.Calls[0].Module != "std/web" || r.Calls[0].Outcome != "error" || r.Calls[0].Code != 503 {
+		t.Fatalf("calls: %+v", r.Calls)
+	}
+	if _, err := json.Marshal(r); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+
+	as, _ = Run(&Conf{
+		Script:  `web.get("http://example.com/"); web.get("http://example.com/")`,
+		Logger:  testLogger{t},
+		NetMock: true,
+		NetMax:  1,
+	})
+	if r := as.Report(); r.Termination != TermQuota || !r.Calls[0].DryRun {
+		t.Fatalf("report: %+v", r)
+	}
+
+	as, _ = Run(&Conf{
+		Script:  `while(1){}`,
+		Logger:  testLogger{t},
+		Timeout: 10 * time.Millisecond,
+	})
+	if r := as.Report(); r.Termination != TermTimeout {
+		t.Fatalf("report: %+v", r)
+	}
+
+	// the script cannot break the export
+	as, err = Run(&Conf{Script: `JSON = undefined; ({a: 1})`, Logger: testLogger{t}})
+	if err != nil || string(as.Report().Result) != `{"a":1}` {
+		t.Fatalf("JSON replaced: %v %+v", err, as.Report())
+	}
+
+	t0 := time.Now()
+	as, _ = Run(&Conf{
+		Script:  `({toJSON: function(){ while(1){} }})`,
+		Logger:  testLogger{t},
+		Timeout: 50 * time.Millisecond,
+	})
+	if r := as.Report(); r.Result != nil || time.Since(t0) > time.Second {
+		t.Fatalf("toJSON: %+v", r)
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	run := func(script string) error {
+		_, err := Run(&Conf{
+			Script:  script,
+			Logger:  testLogger{t},
+			NetMock: true,
+			NetMax:  1,
+			Timeout: 20 * time.Millisecond,
+		})
+		return err
+	}
+
+	var cerr *CompileError
+	if err := run("var x = 1\nvar = 2"); !errors.As(err, &cerr) || cerr.Line != 2 {
+		t.Fatalf("expected compile error on line 2: %v", err)
+	}
+
+	var serr *ScriptException
+	if err := run(`function f(){ throw new Error('oops') }; f()`); !errors.As(err, &serr) || !strings.Contains(serr.Stack, "at f") {
+		t.Fatalf("expected exception: %v", err)
+	}
+	if err := run(`(async function(){ throw new Error('later') })()`); !errors.As(err, &serr) || !strings.Contains(serr.Message, "later") {
+		t.Fatalf("expected rejection: %v", err)
+	}
+
+	var terr *TimeoutError
+	if err := run(`while(1){}`); !errors.As(err, &terr) || terr.Hard {
+		t.Fatalf("expected timeout: %v", err)
+	}
+
+	var qerr *QuotaError
+	if err := run(`web.get("http://example.com/"); web.get("http://example.com/")`); !errors.As(err, &qerr) || qerr.Limit != 1 {
+		t.Fatalf("expected quota: %v", err)
+	}
+
+	var merr *ModuleNotFoundError
+	if err := run(`module('std/nonesuch')`); !errors.As(err, &merr) || merr.Name != "std/nonesuch" {
+		t.Fatalf("expected module not found: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := RunContext(ctx, &Conf{Script: `while(1){}`, Logger: testLogger{t}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled: %v", err)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	gone := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(gone)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	t0 := time.Now()
+	as, err := RunContext(ctx, &Conf{
+		Script:     `web.get(url); 'finished'`,
+		DataName:   "url",
+		Data:       srv.URL,
+		NetMax:     1,
+		NetTimeout: 5 * time.Second,
+		// no Logger
+	})
+	if !errors.Is(err, context.Canceled) || as.Report().Termination != TermCancelled {
+		t.Fatalf("expected cancelled: %v", err)
+	}
+	if dt := time.Since(t0); dt > time.Second {
+		t.Fatalf("not aborted promptly: %v", dt)
+	}
+
+	// the request was abandoned too
+	select {
+	case <-gone:
+	case <-time.After(time.Second):
+		t.Fatalf("request not cancelled")
+	}
+}
+
+func TestLimits(t *testing.T) {
+
+	var serr *StackOverflowError
+	_, err := Run(&Conf{
+		Script:           `function f(n){ return f(n+1) + 1 }; f(0)`,
+		Logger:           testLogger{t},
+		MaxCallStackSize: 100,
+	})
+	if !errors.As(err, &serr) || serr.Limit != 100 {
+		t.Fatalf("expected stack overflow: %v", err)
+	}
+
+	// runtime is still usable afterwards
+	p := NewPool(1)
+	for i := 0; i < 2; i++ {
+		as, err := Run(&Conf{
+			Script: `try { (function f(){ return f() })() } catch(e) {}`,
+			Logger: testLogger{t},
+			Pool:   p,
+		})
+		if !errors.As(err, &serr) || as.Report().Termination != TermStack {
+			t.Fatalf("expected stack overflow: %v", err)
+		}
+	}
+
+	var merr *HeapGrowthError
+	as, err := Run(&Conf{
+		Script:        `var a = []; while(1){ a.push(new Array(10000).fill('x')) }`,
+		Logger:        testLogger{t},
+		Timeout:       10 * time.Second,
+		MaxHeapGrowth: 64 << 20,
+	})
+	if !errors.As(err, &merr) || as.Report().Termination != TermHeapGrowth {
+		t.Fatalf("expected memory limit: %v", err)
+	}
+}
+
+func TestAllowModules(t *testing.T) {
+
+	run := func(script string) (*AS, error) {
+		return Run(&Conf{
+			Script:       script,
+			Logger:       testLogger{t},
+			AllowModules: []string{"std/*", "ext/slack"},
+			DenyModules:  []string{"std/web", "std/smtp"},
+			Libraries:    map[string]string{"x": `exports.x = 1`},
+		})
+	}
+
+	as, err := run(`typeof web + ' ' + typeof module('ext/slack') + ' ' + module('lib/x').x`)
+	if err != nil || as.Result.String() != "undefined object 1" {
+		t.Fatalf("result: %v %v", as.Result, err)
+	}
+
+	var ferr *ModuleForbiddenError
+	for _, mod := range []string{"std/web", "std/smtp", "ext/s3"} {
+		if _, err := run(`module('` + mod + `')`); !errors.As(err, &ferr) || ferr.Name != mod {
+			t.Fatalf("expected %s forbidden: %v", mod, err)
+		}
+	}
+}
+
+func TestNetPolicy(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, other.URL, http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	run := func(pol *NetPolicy, path string) string {
+		as, err := Run(&Conf{
+			Script:    `var r = web.get(url); r.code + ' ' + r.body`,
+			Logger:    testLogger{t},
+			DataName:  "url",
+			Data:      srv.URL + path,
+			NetMax:    1,
+			NetPolicy: pol,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String()
+	}
+
+	if r := run(nil, "/"); r != "200 ok" {
+		t.Fatalf("no policy: %s", r)
+	}
+	if r := run(&NetPolicy{BlockPrivate: true}, "/"); strings.HasPrefix(r, "200") {
+		t.Fatalf("loopback permitted: %s", r)
+	}
+
+	port, _ := strconv.Atoi(srv.URL[strings.LastIndex(srv.URL, ":")+1:])
+	if r := run(&NetPolicy{AllowPorts: []int{port}}, "/redirect"); strings.HasPrefix(r, "200") {
+		t.Fatalf("redirect permitted: %s", r)
+	}
+}
+
+func TestCassette(t *testing.T) {
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("X-N", strconv.Itoa(n))
+		w.Write([]byte("hello " + r.URL.Query().Get("x")))
+	}))
+
+	script := `
+var a = web.get(url + '?x=1'), b = web.get(url + '?x=2')
+var m = module('std/smtp').send({hostname: '127.0.0.1', port: 1}, {to: 'a@example.com', from: 'b@example.com'});
+[a.body, b.body, a.header['X-N'][0], m.code].join(' ')
+`
+	run := func(cas *Cassette) string {
+		as, err := Run(&Conf{
+			Script:   script,
+			Logger:   testLogger{t},
+			DataName: "url",
+			Data:     srv.URL,
+			NetMax:   3,
+			Cassette: cas,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String()
+	}
+
+	rec := module.NewCassette()
+	r1 := run(rec)
+	srv.Close()
+
+	file := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Save(file); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	cas, err := module.LoadCassette(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	r2 := run(cas)
+	if r1 != "hello 1 hello 2 1 500" || r2 != r1 {
+		t.Fatalf("replay: %q, recorded %q", r2, r1)
+	}
+
+	// nothing left to replay
+	script = `web.get(url + '?x=1').body`
+	if r := run(cas); !strings.Contains(r, "replay: no recorded response") {
+		t.Fatalf("replay again: %q", r)
+	}
+}
+
+func TestCassetteRedact(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "tok-abc", "token_type": "bearer", "expires_in": 3600, "refresh_token": "ref-xyz"}`))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	script := `
+var a = web.fetch(url + '/api', {oauth2: 'cc'}).body
+var b = web.fetch(url + '/api', {method: 'POST', body: 'hi', headers: {'X-Api-Key': 'key-123', 'X-Custom': 'cust-456'},
+                                 sign: {key: 'k', type: 'hmac', header: 'X-My-Sig'}}).body
+var c = web.get(url + '/big').body.length;
+[a, b, c].join(' ')
+`
+	run := func(cas *Cassette) string {
+		as, err := Run(&Conf{
+			Script:          script,
+			Logger:          testLogger{t},
+			DataName:        "url",
+			Data:            srv.URL,
+			NetMax:          4,
+			Cassette:        cas,
+			MaxResponseSize: 100,
+			OAuth2:          OAuth2Clients{"cc": {TokenURL: srv.URL + "/token", ClientID: "client", ClientSecret: "s3kr1t", AuthInParams: true}},
+			SigningKeys:     SigningKeys{"k": {Secret: "sig-secret"}},
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String()
+	}
+
+	rec := module.NewCassette()
+	rec.RedactHeaders = []string{"x-custom"}
+	r1 := run(rec)
+	srv.Close()
+
+	file := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Save(file); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	js, _ := ioutil.ReadFile(file)
+	for _, secret := range []string{"s3kr1t", "tok-abc", "ref-xyz", "key-123", "cust-456"} {
+		if strings.Contains(string(js), secret) {
+			t.Errorf("%s recorded", secret)
+		}
+	}
+	for _, it := range rec.Interactions {
+		if v := it.ReqHeader.Get("X-My-Sig"); v != "" && v != "REDACTED" {
+			t.Errorf("signature recorded: %s", v)
+		}
+		if len(it.Body) > 101 {
+			t.Errorf("response not limited: %d bytes", len(it.Body))
+		}
+	}
+
+	// the redacted token can still be replayed
+	cas, err := module.LoadCassette(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if r2 := run(cas); r1 != "ok ok 100" || r2 != r1 {
+		t.Fatalf("replay: %q, recorded %q", r2, r1)
+	}
+}
+
+// ext/slack posts the token in a form
+func TestCassetteRedactForm(t *testing.T) {
+
+	cas := module.NewCassette()
+	Run(&Conf{
+		Script:    `module('ext/slack').post('xoxb-s3kr1t', 'chan', [])`,
+		NetMax:    1,
+		Logger:    testLogger{t},
+		Cassette:  cas,
+		NetPolicy: &NetPolicy{DenyHosts: []string{"slack.com", "*.slack.com"}},
+	})
+
+	if len(cas.Interactions) == 0 {
+		t.Fatalf("nothing recorded")
+	}
+	js, _ := json.Marshal(cas)
+	if strings.Contains(string(js), "xoxb-s3kr1t") {
+		t.Errorf("token recorded: %s", js)
+	}
+	if !strings.Contains(cas.Interactions[0].ReqBody, "channel=chan") {
+		t.Errorf("form not kept: %s", cas.Interactions[0].ReqBody)
+	}
+}
+
+func TestMocks(t *testing.T) {
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mocks.yaml")
+	ioutil.WriteFile(file, []byte(`
+- module: std/web
+  method: POST
+  dest: "https://api.example.com/*"
+  code: 503
+  body: try later
+- module: std/web
+  dest: "https://down.example.com/*"
+  error: connection refused
+  latency: 20ms
+- module: ext/*
+  code: 401
+`), 0644)
+
+	mocks, err := module.LoadMocks(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	as, err := Run(&Conf{
+		Script: `
+var a = web.post('https://api.example.com/v1/alert', {}, 'x')
+var b = web.get('https://api.example.com/v1/alert')
+var c = web.get('https://down.example.com/')
+var d = module('ext/slack').post('token', 'chan', [])
+;[a.code, a.body, b.code, b.message, c.code, c.body, d.code].join('|')
+`,
+		Logger:  testLogger{t},
+		NetMock: true,
+		NetMax:  5,
+		Mocks:   mocks,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != "503|try later|200|not tried|500|connection refused|500" {
+		t.Fatalf("result: %s", r)
+	}
+	if r := as.Report(); r.NetErrs != 3 || r.NetTime < 20*time.Millisecond {
+		t.Fatalf("report: %+v", r)
+	}
+}
+
+func TestWebFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		fmt.Fprintf(w, "%s %s %s %s %s", r.Method, r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), body)
+	}))
+	defer srv.Close()
+
+	as, err := Run(&Conf{
+		Script: `
+var r = [
+  web.fetch(url, {method: 'put', query: {a: 1, b: ['x', 'y']}, json: {n: 2}, auth: {bearer: 'tok'}}).body,
+  web.fetch(url, {headers: {'Content-Type': 'text/plain'}, body: 'hi', method: 'POST', auth: {username: 'u', password: 'p'}}).body,
+  web.patch(url, {}, 'p').body,
+  web.delete(url).body,
+  web.head(url).header['X-Method'][0],
+  web.fetch(url + '/redirect', {follow_redirects: false}).code,
+  web.fetch(url + '/slow', {timeout: 50}).code,
+]
+r.join('|')
+`,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   10,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	exp := `PUT a=1&b=x&b=y Bearer tok application/json {"n":2}|POST  Basic dTpw text/plain hi|PATCH    p|DELETE    |HEAD|302|500`
+	if r := as.Result.String(); r != exp {
+		t.Fatalf("result:\n%s\nexpected:\n%s", r, exp)
+	}
+}
+
+func TestWebJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"a": [1, 2], "b": "x"}`))
+		case "/bad":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"a": `))
+		default:
+			w.Write([]byte(strings.Repeat("x", 100)))
+		}
+	}))
+	defer srv.Close()
+
+	as, err := Run(&Conf{
+		Script: `
+var a = web.get(url + '/json').json()
+var b = web.fetch(url + '/json', {decode_json: true}).data
+var c = web.get(url + '/json').data
+web.decode_json = true
+var d = web.get(url + '/bad')
+var e = web.get(url + '/big')
+var f; try { web.get(url + '/bad').json() } catch(ex) { f = ex.message }
+;[a.a[1], b.b, c, d.data, !!d.json_error, e.body.length, e.truncated, f].join('|')
+`,
+		Logger:          testLogger{t},
+		DataName:        "url",
+		Data:            srv.URL,
+		NetMax:          10,
+		MaxResponseSize: 50,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	r := as.Result.String()
+	if !strings.HasPrefix(r, "2|x|||true|50|true|") || !strings.Contains(r, "not valid json") {
+		t.Fatalf("result: %s", r)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var lock sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		lock.Unlock()
+
+		switch {
+		case r.URL.Path == "/slow" && n == 1:
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(503)
+		case r.URL.Path == "/slow":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprintf(w, "%d", n)
+		case r.URL.Path == "/later" && n == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+		case r.URL.Path == "/flaky" && n < 3, r.URL.Path == "/down":
+			w.WriteHeader(503)
+		default:
+			fmt.Fprintf(w, "%d %s", n, body)
+		}
+	}))
+	defer srv.Close()
+
+	run := func(script string, netmax int, retry *RetryPolicy) (*AS, error) {
+		return Run(&Conf{
+			Script:   script,
+			Logger:   testLogger{t},
+			DataName: "url",
+			Data:     srv.URL,
+			NetMax:   netmax,
+			Retry:    retry,
+			Timeout:  5 * time.Second,
+		})
+	}
+
+	// per call
+	as, err := run(`web.fetch(url + '/flaky', {method: 'POST', body: 'x', retry: {attempts: 3, backoff: 10}}).body`, 3, nil)
+	if err != nil || as.Result.String() != "3 x" || as.NetReqs != 3 {
+		t.Fatalf("flaky: %v %v", as.Result, err)
+	}
+
+	// conf default, honoring retry-after
+	as, err = run(`web.get(url + '/later').body`, 2, &RetryPolicy{Attempts: 2, Backoff: 10})
+	if err != nil || as.Result.String() != "2 " || as.Report().NetTime < time.Second {
+		t.Fatalf("later: %v %v", as.Result, err)
+	}
+
+	// retry-after is longer than we will wait
+	lock.Lock()
+	hits["/later"] = 0
+	lock.Unlock()
+	as, err = run(`web.get(url + '/later').code`, 2, &RetryPolicy{Attempts: 2, Backoff: 10, MaxBackoff: 100})
+	if err != nil || as.Result.ToInteger() != 429 || hits["/later"] != 1 {
+		t.Fatalf("later: %v %v", as.Result, err)
+	}
+
+	// gives up
+	as, err = run(`web.retry = {attempts: 2, backoff: 10}; web.get(url + '/down').code`, 2, nil)
+	if err != nil || as.Result.ToInteger() != 503 || hits["/down"] != 2 {
+		t.Fatalf("down: %v %v", as.Result, err)
+	}
+
+	// the timeout is per attempt, not for all of them
+	as, err = Run(&Conf{Script: `web.fetch(url + '/slow', {retry: {attempts: 2, backoff: 10}}).body`, Logger: testLogger{t},
+		DataName: "url", Data: srv.URL, NetMax: 2, NetTimeout: 300 * time.Millisecond})
+	if err != nil || as.Result.String() != "2" {
+		t.Fatalf("slow: %v %v", as.Result, err)
+	}
+
+	// retries count against NetMax
+	_, err = run(`web.get(url + '/down').code`, 2, &RetryPolicy{Attempts: 5, Backoff: 10})
+	var qerr *QuotaError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("expected quota: %v", err)
+	}
+}
+
+func TestTLSProfiles(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	profiles := TLSProfiles{
+		"partner": {CAPEM: ca, Pins: []string{module.CertPin(srv.Certificate())}},
+		"badpin":  {CAPEM: ca, Pins: []string{"sha256/" + strings.Repeat("A", 43) + "="}},
+	}
+
+	run := func(script string) (string, error) {
+		as, err := Run(&Conf{
+			Script:      script,
+			Logger:      testLogger{t},
+			DataName:    "url",
+			Data:        srv.URL,
+			NetMax:      2,
+			TLSProfiles: profiles,
+		})
+		if err != nil {
+			return "", err
+		}
+		return as.Result.String(), nil
+	}
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`web.fetch(url, {tls: 'partner'}).code`, "200"},
+		{`web.tls = 'partner'; web.get(url).code`, "200"},
+		{`web.get(url).code`, "500"}, // unknown authority
+		{`web.fetch(url, {tls: 'badpin'}).code`, "500"},
+	}
+
+	for _, test := range tests {
+		r, err := run(test.script)
+		if err != nil || r != test.result {
+			t.Errorf("%s => %s %v", test.script, r, err)
+		}
+	}
+
+	_, err := run(`web.fetch(url, {tls: 'nope'})`)
+	if err == nil || !strings.Contains(err.Error(), "tls profile not found") {
+		t.Errorf("expected profile not found: %v", err)
+	}
+}
+
+func TestTLSInspect(t *testing.T) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test.example.com"},
+		DNSNames:     []string{"test.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10*24*time.Hour + time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	staple, err := ocsp.CreateResponse(cert, cert, ocsp.Response{Status: ocsp.Good, SerialNumber: cert.SerialNumber}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, OCSPStaple: staple}}}
+	srv.StartTLS()
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	as, err := Run(&Conf{
+		Script: `var r = module('std/tls').inspect(addr);
+                         [r.error, r.days_left, r.verified, !!r.verify_error, r.sans.join(' '), r.ocsp_stapled, r.ocsp_status,
+                          r.chain.length, r.chain[0].subject_name, r.version.substr(0,3)].join(',')`,
+		Logger:   testLogger{t},
+		DataName: "addr",
+		Data:     addr,
+		NetMax:   1,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r := as.Result.String(); r != ",10,false,true,test.example.com 127.0.0.1,true,good,1,CN=test.example.com,TLS" {
+		t.Fatalf("inspect: %s", r)
+	}
+
+	// not listening
+	srv.Close()
+	as, err = Run(&Conf{
+		Script:   `module('std/tls').inspect(addr).error`,
+		Logger:   testLogger{t},
+		DataName: "addr",
+		Data:     addr,
+		NetMax:   1,
+	})
+	if err != nil || as.Result.String() == "" {
+		t.Fatalf("expected failure: %v %v", as.Result, err)
+	}
+
+	// dry run
+	as, err = Run(&Conf{
+		Script:  `module('std/tls').inspect('www.example.com').days_left`,
+		Logger:  testLogger{t},
+		NetMax:  1,
+		NetMock: true,
+		Mocks:   Mocks{{Module: "std/tls", Dest: "www.example.com:443", Body: `{"days_left": 3}`}},
+	})
+	if err != nil || as.Result.ToInteger() != 3 {
+		t.Fatalf("dry run: %v %v", as.Result, err)
+	}
+}
+
+func TestWebMultipart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(1 << 20)
+		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "%v", err)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %s;", r.Header.Get("X-Ticket"), r.FormValue("summary"), strings.Join(r.MultipartForm.Value["tag"], "+"))
+		for _, name := range []string{"shot", "log", "raw"} {
+			fh := r.MultipartForm.File[name][0]
+			f, _ := fh.Open()
+			b, _ := ioutil.ReadAll(f)
+			fmt.Fprintf(w, " %s %s %x", fh.Filename, fh.Header.Get("Content-Type"), b)
+		}
+	}))
+	defer srv.Close()
+
+	as, err := Run(&Conf{
+		Script: `var b64 = module('std/encoding/base64');
+                         var form = {
+                           fields: { summary: 'disk full', tag: ['ops', 'disk'] },
+                           files: [
+                             { name: 'shot', filename: 'df.png', type: 'image/png', content: b64.std.decode('AP8Q') },
+                             { name: 'log', filename: 'x.log', type: 'text/plain', content: 'hi' },
+                             { name: 'raw', filename: 'r"b', content: [0, 1, 255] },
+                           ] };
+                         var r = web.post_multipart(url, {'X-Ticket': ['T1']}, form);
+                         r.code + ' ' + r.body`,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   1,
+	})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	exp := "200 T1 disk full ops+disk; df.png image/png 00ff10 x.log text/plain 6869 r\"b application/octet-stream 0001ff"
+	if r := as.Result.String(); r != exp {
+		t.Fatalf("multipart: %s", r)
+	}
+
+	_, err = Run(&Conf{
+		Script:   `web.post_multipart(url, {}, {files: [{filename: 'x'}]})`,
+		Logger:   testLogger{t},
+		DataName: "url",
+		Data:     srv.URL,
+		NetMax:   1,
+	})
+	if err == nil || !strings.Contains(err.Error(), "file name is required") {
+		t.Fatalf("expected error: %v", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+
+	eckey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(eckey)
+	keys := SigningKeys{
+		"hook": {Secret: "s3kr1t"},
+		"svc":  {PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), KeyID: "k1"},
+	}
+
+	mac := func(s string) string {
+		return hex.EncodeToString(hmacSum("s3kr1t", s))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ok := false
+
+		switch r.URL.Path {
+		case "/github":
+			ok = r.Header.Get("X-Hub-Signature-256") == "sha256="+mac(string(body))
+		case "/stripe":
+			var ts, sig string
+			for _, p := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+				if strings.HasPrefix(p, "t=") {
+					ts = p[2:]
+				}
+				if strings.HasPrefix(p, "v1=") {
+					sig = p[3:]
+				}
+			}
+			ok = sig == mac(ts+"."+string(body))
+		case "/slack":
+			ts := r.Header.Get("X-Slack-Request-Timestamp")
+			ok = r.Header.Get("X-Slack-Signature") == "v0="+mac("v0:"+ts+":"+string(body))
+		case "/jwt":
+			parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+			if len(parts) != 3 {
+				break
+			}
+			hdr, _ := base64.RawURLEncoding.DecodeString(parts[0])
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			ok = len(sig) == 64 &&
+				ecdsa.Verify(&eckey.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) &&
+				string(hdr) == `{"alg":"ES256","kid":"k1","typ":"JWT"}` &&
+				strings.Contains(string(claims), `"iss":"alerts"`)
+		}
+
+		if !ok {
+			w.WriteHeader(401)
+		}
+	}))
+	defer srv.Close()
+
+	run := func(script string) (string, error) {
+		as, err := Run(&Conf{
+			Script:      script,
+			Logger:      testLogger{t},
+			DataName:    "url",
+			Data:        srv.URL,
+			NetMax:      5,
+			SigningKeys: keys,
+		})
+		if err != nil {
+			return "", err
+		}
+		return as.Result.String(), nil
+	}
+
+	r, err := run(`
+		var codes = []
+		codes.push( web.fetch(url + '/github', {method: 'POST', body: 'hello', sign: {type: 'github', key: 'hook'}}).code )
+		codes.push( web.fetch(url + '/stripe', {method: 'POST', json: {a: 1}, sign: {type: 'stripe', key: 'hook'}}).code )
+		codes.push( web.fetch(url + '/slack', {method: 'POST', body: 'x=y', sign: {type: 'slack', key: 'hook'}}).code )
+		codes.push( web.fetch(url + '/jwt', {sign: {type: 'jwt', key: 'svc', alg: 'ES256', claims: {iss: 'alerts'}}}).code )
+		codes.push( web.fetch(url + '/github', {method: 'POST', body: 'hello', sign: {type: 'slack', key: 'hook'}}).code )
+		codes.join(' ')`)
+	if err != nil || r != "200 200 200 200 401" {
+		t.Fatalf("fetch: %s %v", r, err)
+	}
+
+	// standalone
+	r, err = run(`
+		var sign = module('std/sign')
+		var h = sign.headers('POST', url, {'X-Thing': ['1']}, 'hello',
+                                     {type: 'hmac', key: 'hook', header: 'X-Sig', prefix: 'sha256=', timestamp_header: 'X-Ts'})
+		var t = sign.jwt({key: 'hook', claims: {sub: 'me'}})
+		;[h['X-Thing'][0], h['X-Ts'][0], h['X-Sig'][0], t].join(' ')`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	f := strings.Fields(r)
+	if len(f) != 4 || f[0] != "1" || f[2] != "sha256="+mac(f[1]+".hello") {
+		t.Fatalf("headers: %s", r)
+	}
+	jp := strings.Split(f[3], ".")
+	if len(jp) != 3 || base64.RawURLEncoding.EncodeToString(hmacSum("s3kr1t", jp[0]+"."+jp[1])) != jp[2] {
+		t.Fatalf("jwt: %s", f[3])
+	}
+
+	_, err = run(`web.fetch(url, {sign: {type: 'github', key: 'nope'}})`)
+	if err == nil || !strings.Contains(err.Error(), "signing key not found") {
+		t.Fatalf("expected key not found: %v", err)
+	}
+}
+
+func hmacSum(key, data string) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func TestOAuth2(t *testing.T) {
+	var lock sync.Mutex
+	var grants []string
+	n := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			id, secret, _ := r.BasicAuth()
+			if id != "client" || secret != "s3kr1t" || r.Form.Get("scope") != "read write" {
+				w.WriteHeader(401)
+				fmt.Fprintf(w, `{"error": "invalid_client"}`)
+				return
+			}
+			n++
+			g := r.Form.Get("grant_type") + ":" + r.Form.Get("refresh_token")
+			grants = append(grants, g)
+			if r.Form.Get("grant_type") == "refresh_token" {
+				// rotate, and expire immediately
+				fmt.Fprintf(w, `{"access_token": "tok%d", "token_type": "bearer", "expires_in": 1, "refresh_token": "r%d"}`, n, n)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": "tok%d", "token_type": "bearer", "expires_in": "3600"}`, n)
+		case "/reject":
+			w.WriteHeader(401)
+		default:
+			fmt.Fprintf(w, "%s", r.Header.Get("Authorization"))
+		}
+	}))
+	defer srv.Close()
+
+	clients := OAuth2Clients{
+		"cc":      {TokenURL: srv.URL + "/token", ClientID: "client", ClientSecret: "s3kr1t", Scopes: []string{"read", "write"}},
+		"refresh": {TokenURL: srv.URL + "/token", ClientID: "client", ClientSecret: "s3kr1t", Scopes: []string{"read", "write"}, RefreshToken: "r0"},
+		"bad":     {TokenURL: srv.URL + "/token", ClientID: "client", ClientSecret: "wrong"},
+	}
+	cache := module.NewTokenCache()
+
+	run := func(fed, script string) (string, int) {
+		as, err := Run(&Conf{
+			Script:     script,
+			Logger:     testLogger{t},
+			DataName:   "url",
+			Data:       srv.URL,
+			NetMax:     2,
+			Federation: fed,
+			OAuth2:     clients,
+			TokenCache: cache,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String(), as.NetReqs
+	}
+
+	tests := []struct {
+		fed    string
+		script string
+		result string
+		reqs   int
+	}{
+		{"a", `web.fetch(url + '/api', {oauth2: 'cc'}).body`, "Bearer tok1", 2},
+		{"a", `web.oauth2 = 'cc'; web.get(url + '/api').body`, "Bearer tok1", 1}, // cached
+		{"b", `web.fetch(url + '/api', {oauth2: 'cc'}).body`, "Bearer tok2", 2},  // another tenant
+		{"a", `web.fetch(url + '/reject', {oauth2: 'cc'}).code`, "401", 1},
+		{"a", `web.fetch(url + '/api', {oauth2: 'cc'}).body`, "Bearer tok3", 2}, // rejected token was discarded
+		{"a", `module('std/oauth2').token('cc').access_token`, "tok3", 0},
+		{"a", `web.fetch(url + '/api', {oauth2: 'refresh'}).body`, "Bearer tok4", 2},
+		{"a", `web.fetch(url + '/api', {oauth2: 'refresh'}).body`, "Bearer tok5", 2}, // expired, refreshed with the rotated token
+		{"a", `web.fetch(url + '/api', {oauth2: 'bad'}).code`, "500", 2},
+	}
+
+	for _, test := range tests {
+		r, reqs := run(test.fed, test.script)
+		if r != test.result || reqs != test.reqs {
+			t.Errorf("%s => %s %d, expected %s %d", test.script, r, reqs, test.result, test.reqs)
+		}
+	}
+
+	lock.Lock()
+	g := strings.Join(grants, " ")
+	lock.Unlock()
+	if g != "client_credentials: client_credentials: client_credentials: refresh_token:r0 refresh_token:r4" {
+		t.Errorf("grants: %s", g)
+	}
+
+	_, err := Run(&Conf{
+		Script: `web.fetch(url, {oauth2: 'nope'})`,
+		Logger: testLogger{t}, DataName: "url", Data: srv.URL, NetMax: 2,
+	})
+	if err == nil || !strings.Contains(err.Error(), "oauth2 client not found") {
+		t.Errorf("expected not found: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	script := `var store = module('std/store');
+                   var r = store.get('count');
+                   var n = r.found ? r.value.n + 1 : 1;
+                   store.put('count', {n: n});
+                   n`
+
+	run := func(cf *Conf) int64 {
+		cf.Script = script
+		cf.Logger = testLogger{t}
+		as, err := Run(cf)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.ToInteger()
+	}
+
+	// files in DataDir
+	for i := int64(1); i <= 3; i++ {
+		if n := run(&Conf{DataDir: dir, Federation: "acme/1"}); n != i {
+			t.Fatalf("datadir: %d, expected %d", n, i)
+		}
+	}
+	if n := run(&Conf{DataDir: dir, Federation: "other"}); n != 1 {
+		t.Fatalf("tenants not separate: %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "store", "acme%2F1", "count.json")); err != nil {
+		t.Fatalf("store file: %v", err)
+	}
+
+	// default, nothing persists
+	run(&Conf{})
+	if n := run(&Conf{}); n != 1 {
+		t.Fatalf("memory: %d", n)
+	}
+
+	// provided by the host
+	st := memstore.New()
+	run(&Conf{Store: st})
+	if n := run(&Conf{Store: st}); n != 2 {
+		t.Fatalf("host store: %d", n)
+	}
+}
+
+// each run has its own store on DataDir, updates must still be atomic
+func TestStoreConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	const nrun, nincr = 20, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < nrun; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Run(&Conf{Script: `var s = module('std/store'); for(var i=0; i<n; i++){ s.incr('n') }`,
+				Logger: testLogger{t}, DataDir: dir, DataName: "n", Data: nincr, Timeout: 30 * time.Second})
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	as, err := Run(&Conf{Script: `module('std/store').get('n').value`, Logger: testLogger{t}, DataDir: dir})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if n := as.Result.ToInteger(); n != nrun*nincr {
+		t.Errorf("lost updates: %d, expected %d", n, nrun*nincr)
+	}
+}
+
+// incr + cas are atomic across runs, not just within one
+func TestStoreAtomic(t *testing.T) {
+	dir := t.TempDir()
+	const nrun, nincr = 20, 50
+
+	// a counter updated with cas, retrying on conflict
+	script := `var s = module('std/store');
+                   for(var i=0; i<n; i++){
+                       s.incr('incr');
+                       do { var r = s.get('cas') } while( !s.cas('cas', r.version, (r.value || 0) + 1).ok )
+                   }`
+
+	var wg sync.WaitGroup
+	for i := 0; i < nrun; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Run(&Conf{Script: script, Logger: testLogger{t}, DataDir: dir, DataName: "n", Data: nincr, Timeout: 30 * time.Second})
+			if err != nil {
+				t.Errorf("error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	as, err := Run(&Conf{Script: `var s = module('std/store'); s.get('incr').value + ' ' + s.get('cas').value`, Logger: testLogger{t}, DataDir: dir})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if r, exp := as.Result.String(), fmt.Sprintf("%d %d", nrun*nincr, nrun*nincr); r != exp {
+		t.Errorf("lost updates: %s, expected %s", r, exp)
+	}
+}
+
+func TestStoreOps(t *testing.T) {
+	st := memstore.New()
+
+	run := func(script string) string {
+		as, err := Run(&Conf{Script: script, Logger: testLogger{t}, Store: st, Federation: "acme"})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String()
+	}
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`var s = module('std/store');
+                  s.put('user/1', {name: 'bob'}); s.put('user/2', 'x'); s.put('tmp', 1, {ttl: 60000});
+                  s.list('user/').join(' ') + ' ' + s.list('').length`, "user/1 user/2 3"},
+		{`var s = module('std/store'); var r = s.get('tmp');
+                  r.found + ' ' + (r.expires > Date.now()) + ' ' + (r.version > 0)`, "true true true"},
+		{`var s = module('std/store'); [s.delete('user/2'), s.delete('user/2'), s.get('user/2').found].join(' ')`, "true false false"},
+		{`var s = module('std/store'); [s.incr('n'), s.incr('n', 5), s.incr('n', -2), s.get('n').value].join(' ')`, "1 6 4 4"},
+		{`var s = module('std/store'); var r = s.get('user/1');
+                  var a = s.cas('user/1', r.version, {name: 'alice'});
+                  var b = s.cas('user/1', r.version, {name: 'carol'});
+                  var c = s.cas('new', 0, 1);
+                  [a.ok, a.version > r.version, b.ok, c.ok, s.get('user/1').value.name].join(' ')`, "true true false true alice"},
+	}
+
+	for _, test := range tests {
+		if r := run(test.script); r != test.result {
+			t.Errorf("%s => %s, expected %s", test.script, r, test.result)
+		}
+	}
+
+	_, err := Run(&Conf{Script: `var s = module('std/store'); s.put('s', 'x'); s.incr('s')`, Logger: testLogger{t}})
+	if err == nil || !strings.Contains(err.Error(), "not a number") {
+		t.Errorf("expected not a number: %v", err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	st := memstore.New()
+
+	run := func(script string, dry bool) string {
+		as, err := Run(&Conf{Script: script, Logger: testLogger{t}, Store: st, Federation: "acme", NetMock: dry})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return as.Result.String()
+	}
+
+	allow := `var th = module('std/throttle'); th.allow('page', {max: 2, per: 60000})`
+	seen := `var th = module('std/throttle'); th.dedup.seen('ev-1', 60000)`
+
+	tests := []struct {
+		script string
+		dry    bool
+		result string
+	}{
+		// state persists across runs
+		{allow, false, "true"},
+		{allow, false, "true"},
+		{allow, false, "false"},
+		{`var th = module('std/throttle'); th.allow('other', {max: 2, per: 60000})`, false, "true"},
+		{seen, false, "false"},
+		{seen, false, "true"},
+		// dry runs do not share state with real runs, nor with std/store
+		{seen, true, "false"},
+		{`module('std/store').list('').length`, false, "0"},
+
+		{`var th = module('std/throttle'); var r = [];
+                  for(var i=0; i<5; i++){ var d = th.digest('disk', {n: i}, {per: 60000, keep: 2}); r.push(d.send) }
+                  r.join(' ')`, false, "true false false false false"},
+		{`var th = module('std/throttle'); var d = th.flush('disk');
+                  [d.send, d.suppressed, d.events.map(function(e){ return e.n }).join(','), d.first <= d.last].join(' ')`, false, "true 4 1,2 true"},
+		{`var th = module('std/throttle'); var d = th.flush('disk'); [d.send, d.suppressed].join(' ')`, false, "false 0"},
+		{`var th = module('std/throttle'); var d = th.flush('nothing'); d.send`, false, "false"},
+	}
+
+	for _, test := range tests {
+		if r := run(test.script, test.dry); r != test.result {
+			t.Errorf("%s => %s, expected %s", test.script, r, test.result)
+		}
+	}
+
+	// the first event after the window gets the summary
+	digest := `var th = module('std/throttle'); var d = th.digest('cpu', 'hot', {per: 50});
+                   [d.send, d.suppressed, d.events.join(',')].join(' ')`
+	for _, exp := range []string{"true 0 ", "false 0 ", "false 0 "} {
+		if r := run(digest, false); r != exp {
+			t.Errorf("digest => %s, expected %s", r, exp)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if r := run(digest, false); r != "true 2 hot,hot" {
+		t.Errorf("digest => %s, expected true 2 hot,hot", r)
+	}
+
+	for _, opts := range []string{"{max: 1}", "{per: 1000}", "{max: 0, per: 1000}"} {
+		_, err := Run(&Conf{Script: `module('std/throttle').allow('x', ` + opts + `)`, Logger: testLogger{t}})
+		if err == nil {
+			t.Errorf("expected error for %s", opts)
+		}
+	}
+}
+
+// throttle state is shared by concurrent runs
+func TestThrottleConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	const nrun, nev, max = 10, 20, 7
+
+	script := `var th = module('std/throttle'); var allowed = 0, unseen = 0, sent = 0;
+                   for(var i=0; i<n; i++){
+                       if( th.allow('a', {max: ` + strconv.Itoa(max) + `, per: 60000}) ) allowed++
+                       if( !th.dedup.seen('ev-' + i, 60000) ) unseen++
+                       if( th.digest('d', i, {per: 60000}).send ) sent++
+                   }
+                   [allowed, unseen, sent].join(' ')`
+
+	var lock sync.Mutex
+	var allowed, unseen, sent int
+
+	var wg sync.WaitGroup
+	for i := 0; i < nrun; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			as, err := Run(&Conf{Script: script, Logger: testLogger{t}, DataDir: dir, DataName: "n", Data: nev, Timeout: 30 * time.Second})
+			if err != nil {
+				t.Errorf("error: %v", err)
+				return
+			}
+			var a, u, s int
+			fmt.Sscan(as.Result.String(), &a, &u, &s)
+
+			lock.Lock()
+			allowed, unseen, sent = allowed+a, unseen+u, sent+s
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// each event is new exactly once, and only the first digest is sent
+	if allowed != max || unseen != nev || sent != 1 {
+		t.Errorf("allowed %d, unseen %d, sent %d, expected %d %d 1", allowed, unseen, sent, max, nev)
+	}
+
+	as, err := Run(&Conf{Script: `module('std/throttle').flush('d').suppressed`, Logger: testLogger{t}, DataDir: dir})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if n := as.Result.ToInteger(); n != nrun*nev-1 {
+		t.Errorf("suppressed %d, expected %d", n, nrun*nev-1)
+	}
+}
+
+func TestSMTPOpts(t *testing.T) {
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`var r = module('std/smtp').send({hostname: 'mail.example.com', tls: 'implicit', auth: 'cram-md5', username: 'u'},
+                  {to: ['a@example.com', {address: 'b@example.com', name: 'Bee'}], cc: 'Cee <c@example.com>', bcc: 'd@example.com', from: 'e@example.com'});
+                  r.code + ' ' + r.message`, "200 not tried"},
+		{`try { module('std/smtp').send({hostname: 'mail.example.com', tls: 'maybe'}, {to: 'a@example.com'}) } catch(e) { 'error ' + e }`,
+			"error GoError: smtp: invalid tls 'maybe'"},
+		{`try { module('std/smtp').send({hostname: 'mail.example.com'}, {to: [], from: 'e@example.com'}) } catch(e) { 'error ' + e }`,
+			"error GoError: smtp: no recipients"},
+	}
+
+	for _, test := range tests {
+		as, err := Run(&Conf{Script: test.script, Logger: testLogger{t}, NetMock: true, NetMax: 1})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if r := as.Result.String(); r != test.result {
+			t.Errorf("%s => %s, expected %s", test.script, r, test.result)
+		}
+	}
+}
+
+func TestSMTPDKIM(t *testing.T) {
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	dkim := DKIMKeys{"example.com": &DKIMKey{Selector: "mail", PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))}}
+
+	send := func(from string) string {
+		cas := module.NewCassette()
+		_, err := Run(&Conf{
+			Script:   `module('std/smtp').send({hostname: '127.0.0.1', port: 1}, {to: 'a@example.net', from: from, subject: 'hi', text: 'hello'})`,
+			Logger:   testLogger{t},
+			DataName: "from",
+			Data:     from,
+			NetMax:   1,
+			Cassette: cas,
+			DKIM:     dkim,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return cas.Interactions[0].ReqBody
+	}
+
+	for _, from := range []string{"b@example.com", "Ops <ops@example.com>", `"x@example.org"@example.com`} {
+		if m := send(from); !strings.HasPrefix(m, "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=example.com; s=mail;") {
+			t.Errorf("%s not signed: %s", from, m)
+		}
+	}
+	for _, from := range []string{"b@example.org", "Ops <ops@example.org>", "not an address"} {
+		if m := send(from); strings.Contains(m, "DKIM-Signature") {
+			t.Errorf("%s signed: %s", from, m)
+		}
+	}
+}
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -13,7 +14,7 @@ import (
 	"time"
 
 	"github.com/jaw0/go-alertscript"
-	_ "github.com/jaw0/go-alertscript/module/std/memstore" // mock kvs
+	"github.com/jaw0/go-alertscript/module"
 )
 
 type event struct {
@@ -33,10 +34,16 @@ type Logger struct{}
 
 func main() {
 	web_n := false
-	var evtType string
+	report := false
+	var evtType, record, replay, mockFile, dataDir string
 
 	flag.BoolVar(&web_n, "n", false, "do not perform web requests")
+	flag.BoolVar(&report, "r", false, "print the run report")
 	flag.StringVar(&evtType, "e", "yes", "event type")
+	flag.StringVar(&record, "record", "", "record network requests to file")
+	flag.StringVar(&replay, "replay", "", "replay network requests from file")
+	flag.StringVar(&mockFile, "mocks", "", "mock responses from json/yaml file (implies -n)")
+	flag.StringVar(&dataDir, "d", "", "data directory (libraries, store)")
 	flag.Parse()
 
 	args := flag.Args()
@@ -66,8 +73,30 @@ func main() {
 		os.Exit(1)
 	}
 
+	var cas *alertscript.Cassette
+	switch {
+	case replay != "":
+		cas, err = module.LoadCassette(replay)
+		if err != nil {
+			fmt.Printf("cannot load cassette: %v\n", err)
+			os.Exit(1)
+		}
+	case record != "":
+		cas = module.NewCassette()
+	}
+
+	var mocks alertscript.Mocks
+	if mockFile != "" {
+		mocks, err = module.LoadMocks(mockFile)
+		if err != nil {
+			fmt.Printf("cannot load mocks: %v\n", err)
+			os.Exit(1)
+		}
+		web_n = true
+	}
+
 	// run using typical settings, actual production servers may vary...
-	_, err = alertscript.Run(&alertscript.Conf{
+	as, err := alertscript.Run(&alertscript.Conf{
 		Script:   string(script),
 		Timeout:  time.Second,
 		NetMock:  web_n,
@@ -75,8 +104,22 @@ func main() {
 		Logger:   Logger{},
 		DataName: "event",
 		Data:     data,
+		Cassette: cas,
+		Mocks:    mocks,
+		DataDir:  dataDir,
 	})
 
+	if record != "" {
+		if err := cas.Save(record); err != nil {
+			fmt.Printf("cannot save cassette: %v\n", err)
+		}
+	}
+
+	if report && as != nil {
+		js, _ := json.MarshalIndent(as.Report(), "", "  ")
+		fmt.Printf("%s\n", js)
+	}
+
 	if err != nil {
 		fmt.Printf("ERROR: %v\n", err)
 		os.Exit(1)
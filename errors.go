@@ -0,0 +1,188 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 17:20 (EDT)
+// Function: errors returned from Compile + Run
+
+package alertscript
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"github.com/jaw0/go-alertscript/module"
+)
+
+const maxStackLines = 20
+
+// use errors.As to determine what went wrong:
+//
+//	var terr *alertscript.TimeoutError
+//	if errors.As(err, &terr) { ... }
+//
+// cancellation by the caller's context returns context.Canceled (use errors.Is)
+
+// the script (or library) does not parse or compile
+type CompileError struct {
+	Name    string // script or library name
+	Line    int
+	Column  int
+	Message string
+}
+
+// the script threw an exception, and did not catch it
+type ScriptException struct {
+	Message string      // the exception, and where it was thrown
+	Stack   string      // js stack trace, if available
+	Value   interface{} // the exported js value that was thrown
+	exc     *goja.Exception
+}
+
+// the script exceeded Timeout, or HardTimeout
+type TimeoutError struct {
+	Hard  bool
+	Limit time.Duration
+}
+
+// the script made too many network requests
+type QuotaError struct {
+	Limit int
+}
+
+// the process heap grew more than MaxHeapGrowth while the script ran.
+// not necessarily because of this script
+type HeapGrowthError struct {
+	Limit int64
+	Used  int64
+}
+
+// the script exceeded MaxCallStackSize (eg. runaway recursion)
+type StackOverflowError struct {
+	Limit int
+	Stack string
+}
+
+// module('name') or module('lib/name') was not found
+type ModuleNotFoundError = module.ModuleNotFoundError
+
+// module('name') is not permitted by AllowModules/DenyModules
+type ModuleForbiddenError = module.ModuleForbiddenError
+
+// a connection was refused by the NetPolicy
+type NetPolicyError = module.NetPolicyError
+
+func (e *CompileError) Error() string {
+	if e.Line == 0 {
+		return "SyntaxError: " + e.Message
+	}
+	return fmt.Sprintf("SyntaxError: %s:%d:%d %s", e.Name, e.Line, e.Column, e.Message)
+}
+
+func (e *ScriptException) Error() string {
+	return e.Message
+}
+
+// the underlying *goja.Exception, if any
+func (e *ScriptException) Unwrap() error {
+	if e.exc == nil {
+		return nil
+	}
+	return e.exc
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Hard {
+		return "timeout - hard limit exceeded!"
+	}
+	return "timeout - maximum runtime exceeded!"
+}
+
+func (e *QuotaError) Error() string {
+	return "Maximum number of web requests exceeded!"
+}
+
+func (e *HeapGrowthError) Error() string {
+	return fmt.Sprintf("heap growth limit exceeded! (%d > %d bytes, process-wide)", e.Used, e.Limit)
+}
+
+func (e *StackOverflowError) Error() string {
+	return "maximum call stack size exceeded!"
+}
+
+// ################################################################
+
+func compileError(name string, err error) error {
+
+	switch e := err.(type) {
+	case parser.ErrorList:
+		if len(e) == 0 {
+			break
+		}
+		return &CompileError{
+			Name:    name,
+			Line:    e[0].Position.Line,
+			Column:  e[0].Position.Column,
+			Message: e[0].Message,
+		}
+	case *goja.CompilerSyntaxError:
+		ce := &CompileError{Name: name, Message: e.Message}
+		if e.File != nil {
+			pos := e.File.Position(e.Offset)
+			ce.Line, ce.Column = pos.Line, pos.Column
+		}
+		return ce
+	}
+
+	return &CompileError{Name: name, Message: err.Error()}
+}
+
+// convert goja errors to ours
+func scriptError(err error, limit int) error {
+
+	switch e := err.(type) {
+	case *goja.InterruptedError:
+		if v, ok := e.Value().(error); ok {
+			return v
+		}
+		return fmt.Errorf("%v", e.Value())
+	case *goja.StackOverflowError:
+		return &StackOverflowError{Limit: limit, Stack: shortStack(e.String())}
+	case *goja.Exception:
+		return &ScriptException{
+			Message: e.Error(),
+			Stack:   e.String(),
+			Value:   e.Value().Export(),
+			exc:     e,
+		}
+	}
+
+	return err
+}
+
+// the stack from a runaway recursion is not interesting past the first few frames
+func shortStack(st string) string {
+	lines := strings.SplitN(st, "\n", maxStackLines+1)
+	if len(lines) > maxStackLines {
+		lines[maxStackLines] = "\t..."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// an unhandled promise rejection. there may be a stack, if it is an Error
+func rejectionError(v goja.Value) error {
+
+	se := &ScriptException{
+		Message: "uncaught (in promise) " + v.String(),
+		Value:   v.Export(),
+	}
+
+	if obj, ok := v.(*goja.Object); ok {
+		if st := obj.Get("stack"); st != nil && !goja.IsUndefined(st) {
+			se.Stack = st.String()
+		}
+	}
+
+	return se
+}
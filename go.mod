@@ -3,16 +3,18 @@ module github.com/jaw0/go-alertscript
 go 1.12
 
 require (
-	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/domodwyer/mailyak v3.1.1+incompatible
-	github.com/dop251/goja v0.0.0-20210111190058-952c20e23c35
+	github.com/dop251/goja v0.0.0-20240220182346-e401ed450204
 	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
 	github.com/jaw0/go-syslog v0.0.0-20220725040130-99118204d26a
 	github.com/minio/minio-go/v7 v7.0.21
 	github.com/pcktdmp/cef v0.2.0
-	github.com/sendgrid/rest v2.6.7+incompatible // indirect
+	github.com/sendgrid/rest v2.6.7+incompatible
 	github.com/sendgrid/sendgrid-go v3.10.5+incompatible
 	github.com/slack-go/slack v0.10.1
 	github.com/twilio/twilio-go v0.20.1
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
+
@@ -0,0 +1,114 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 15:02 (EDT)
+// Function: user provided javascript libraries
+
+package alertscript
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// libraries are loaded from cf.Libraries, or from files in cf.DataDir/lib/name.js
+// in the library, exports, module.exports, and module() work as expected (commonjs style)
+//
+//	var util = module('lib/util')
+
+const libDir = "lib"
+
+type library struct {
+	exports goja.Value
+	loading bool
+}
+
+// the library source is wrapped in a function. keep it on one line, so line numbers match
+const (
+	libHead = "(function(exports, module, require){"
+	libTail = "\n})"
+)
+
+func (m mAS) Library(name string) (goja.Value, error) {
+	as := m.as
+	vm := as.vm
+
+	if l, ok := as.libs[name]; ok {
+		if l.loading {
+			return nil, fmt.Errorf("library cycle: %s -> %s", strings.Join(as.libStack, " -> "), name)
+		}
+		return l.exports, nil
+	}
+
+	src, err := as.librarySource(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := compile(name, libHead+src+libTail)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &library{loading: true}
+	as.libs[name] = l
+	as.libStack = append(as.libStack, name)
+	defer func() {
+		l.loading = false
+		as.libStack = as.libStack[:len(as.libStack)-1]
+	}()
+
+	// module is both module() and module.exports
+	mod := vm.ToValue(module.Loader(m, vm)).(*goja.Object)
+	exports := vm.NewObject()
+	mod.Set("exports", exports)
+
+	fv, err := vm.RunProgram(s.prog)
+	if err != nil {
+		panic(err)
+	}
+	f, _ := goja.AssertFunction(fv)
+
+	_, err = f(goja.Undefined(), exports, mod, mod)
+	if err != nil {
+		// rethrow
+		delete(as.libs, name)
+		panic(err)
+	}
+
+	l.exports = mod.Get("exports")
+	return l.exports, nil
+}
+
+func (as *AS) librarySource(lib string) (string, error) {
+
+	name := strings.TrimPrefix(lib, module.LibPrefix)
+
+	if src, ok := as.cf.Libraries[name]; ok {
+		return src, nil
+	}
+
+	if as.cf.DataDir == "" {
+		return "", &module.ModuleNotFoundError{Name: lib}
+	}
+
+	// stay inside the directory
+	clean := path.Clean("/" + name)
+	file := filepath.Join(as.cf.DataDir, libDir, filepath.FromSlash(clean)+".js")
+
+	src, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", &module.ModuleNotFoundError{Name: lib}
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot load library '%s': %v", name, err)
+	}
+
+	return string(src), nil
+}
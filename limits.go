@@ -0,0 +1,64 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 17:55 (EDT)
+// Function: heap growth + call stack limits
+
+package alertscript
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+// MaxHeapGrowth is a process-wide guard against a runaway script taking
+// down the host, not a per-script memory quota.
+//
+// go does not account memory per goroutine, and goja has no allocation limit,
+// so the heap of the whole process is sampled while the script runs. if it
+// grows more than MaxHeapGrowth since the script started, the script is
+// stopped - whichever script it is. with concurrent scripts (or anything else
+// allocating in the process), an innocent script may be the one stopped.
+// set it well above what any one script should need
+
+const (
+	defaultMaxStack   = 10000
+	memSampleInterval = 10 * time.Millisecond
+	memMetric         = "/memory/classes/heap/objects:bytes"
+)
+
+// interrupt the script if the process heap grows more than MaxHeapGrowth
+// runs until done is closed
+func (as *AS) watchMemory(done chan struct{}) {
+
+	if as.cf.MaxHeapGrowth <= 0 {
+		return
+	}
+
+	base := heapSize()
+	tick := time.NewTicker(memSampleInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			used := heapSize() - base
+			if used > as.cf.MaxHeapGrowth {
+				as.interrupt(TermHeapGrowth, &HeapGrowthError{Limit: as.cf.MaxHeapGrowth, Used: used})
+				return
+			}
+		}
+	}
+}
+
+func heapSize() int64 {
+
+	s := []metrics.Sample{{Name: memMetric}}
+	metrics.Read(s)
+
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(s[0].Value.Uint64())
+}
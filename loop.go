@@ -0,0 +1,194 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 13:31 (EDT)
+// Function: event loop - timers, promises, async requests
+
+package alertscript
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// everything here runs on the js goroutine, except as noted
+type eventLoop struct {
+	jobs     chan func() error // completed work, to run on the loop
+	pending  int               // number of jobs we are waiting for
+	timers   map[int64]*jsTimer
+	timerSeq int64
+	wg       sync.WaitGroup // background requests
+}
+
+type jsTimer struct {
+	fn     goja.Callable
+	args   []goja.Value
+	delay  time.Duration
+	repeat bool
+	timer  *time.Timer
+}
+
+// run until there is nothing left to wait for
+func (as *AS) runLoop() error {
+
+	for as.loop.pending > 0 {
+		select {
+		case f := <-as.loop.jobs:
+			as.loop.pending--
+			if err := f(); err != nil {
+				return err
+			}
+		case <-as.stop:
+			// interrupted while waiting
+			return as.intrErr()
+		}
+	}
+
+	return nil
+}
+
+// add a job to the loop. may be called from any goroutine
+func (as *AS) enqueue(f func() error) {
+	select {
+	case as.loop.jobs <- f:
+	case <-as.quit:
+		// too late
+	}
+}
+
+// if the script produced a promise (eg. from an async function), use its value
+func (as *AS) settle(res goja.Value) (goja.Value, error) {
+
+	if res == nil {
+		return res, nil
+	}
+	p, ok := res.Export().(*goja.Promise)
+	if !ok {
+		return res, nil
+	}
+
+	switch p.State() {
+	case goja.PromiseStateFulfilled:
+		return p.Result(), nil
+	case goja.PromiseStateRejected:
+		return p.Result(), rejectionError(p.Result())
+	}
+	return res, nil
+}
+
+func (as *AS) intrErr() error {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+
+	if err, ok := as.intr.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", as.intr)
+}
+
+// ################################################################
+
+// setTimeout(fn, msec, args...), setInterval(fn, msec, args...)
+func (as *AS) setTimer(c goja.FunctionCall, repeat bool) goja.Value {
+
+	fn, ok := goja.AssertFunction(c.Argument(0))
+	if !ok {
+		panic(as.vm.NewTypeError("setTimeout: argument is not a function"))
+	}
+
+	t := &jsTimer{
+		fn:     fn,
+		delay:  time.Duration(c.Argument(1).ToInteger()) * time.Millisecond,
+		repeat: repeat,
+	}
+	if len(c.Arguments) > 2 {
+		// the arguments belong to the vm's stack, copy them
+		t.args = append([]goja.Value(nil), c.Arguments[2:]...)
+	}
+
+	as.loop.timerSeq++
+	id := as.loop.timerSeq
+	as.loop.timers[id] = t
+	as.startTimer(id, t)
+
+	return as.vm.ToValue(id)
+}
+
+// clearTimeout(id), clearInterval(id)
+func (as *AS) clearTimer(c goja.FunctionCall) goja.Value {
+
+	id := c.Argument(0).ToInteger()
+	t, ok := as.loop.timers[id]
+	if !ok {
+		return nil
+	}
+
+	delete(as.loop.timers, id)
+	if t.timer.Stop() {
+		// it will never arrive
+		as.loop.pending--
+	}
+	return nil
+}
+
+func (as *AS) startTimer(id int64, t *jsTimer) {
+	as.loop.pending++
+	t.timer = time.AfterFunc(t.delay, func() {
+		as.enqueue(func() error { return as.fireTimer(id) })
+	})
+}
+
+func (as *AS) fireTimer(id int64) error {
+
+	t, ok := as.loop.timers[id]
+	if !ok {
+		// cleared
+		return nil
+	}
+
+	if t.repeat {
+		as.startTimer(id, t)
+	} else {
+		delete(as.loop.timers, id)
+	}
+
+	_, err := t.fn(goja.Undefined(), t.args...)
+	return err
+}
+
+func (l *eventLoop) stopTimers() {
+	for id, t := range l.timers {
+		t.timer.Stop()
+		delete(l.timers, id)
+	}
+}
+
+// ################################################################
+
+// run f in the background, returns a promise for the result
+func (m mAS) Async(f func() (interface{}, error)) *goja.Promise {
+	as := m.as
+	p, resolve, reject := as.vm.NewPromise()
+
+	as.loop.pending++
+	as.loop.wg.Add(1)
+
+	go func() {
+		defer as.loop.wg.Done()
+		res, err := f()
+
+		// promises may only be resolved on the loop
+		as.enqueue(func() error {
+			if err != nil {
+				reject(as.vm.NewGoError(err))
+			} else {
+				resolve(res)
+			}
+			return nil
+		})
+	}()
+
+	return p
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 13:05 (EDT)
+// Function: promise returning versions of module functions
+
+package module
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dop251/goja"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Async wraps a blocking function, f(args...) (result, error), into a js function
+// that runs it in the background and returns a Promise. eg.
+//
+//	m.SendAsync = module.Async(aser, vm, m.Send)
+func Async(as MASer, vm *goja.Runtime, f interface{}) func(goja.FunctionCall) goja.Value {
+	return async(as, vm, nil, f)
+}
+
+// AsyncWith is Async, for modules with settings the script may change (eg. web.retry)
+// while a request is in progress. snap is called when the js function is called,
+// and returns a copy of the module. f is a method expression, run on the copy. eg.
+//
+//	m.PostAsync = module.AsyncWith(aser, vm, m.snapshot, (*mod).Post)
+func AsyncWith(as MASer, vm *goja.Runtime, snap func() interface{}, f interface{}) func(goja.FunctionCall) goja.Value {
+	return async(as, vm, snap, f)
+}
+
+func async(as MASer, vm *goja.Runtime, snap func() interface{}, f interface{}) func(goja.FunctionCall) goja.Value {
+
+	fv := reflect.ValueOf(f)
+	ft := fv.Type()
+
+	if ft.Kind() != reflect.Func {
+		panic("module.Async: not a function")
+	}
+	skip := 0
+	if snap != nil {
+		if ft.NumIn() == 0 {
+			panic("module.AsyncWith: not a method")
+		}
+		skip = 1
+	}
+
+	return func(c goja.FunctionCall) goja.Value {
+		// convert the args now, while we are on the js side
+		args, err := exportArgs(vm, ft, skip, c.Arguments)
+		if err != nil {
+			panic(vm.NewTypeError(err.Error()))
+		}
+		if snap != nil {
+			args = append([]reflect.Value{reflect.ValueOf(snap())}, args...)
+		}
+
+		p := as.Async(func() (interface{}, error) {
+			return splitResult(fv.Call(args))
+		})
+		return vm.ToValue(p)
+	}
+}
+
+// the first skip parameters of f are not from js
+func exportArgs(vm *goja.Runtime, ft reflect.Type, skip int, jsargs []goja.Value) ([]reflect.Value, error) {
+
+	var args []reflect.Value
+	n := ft.NumIn() - skip
+
+	for i := 0; i < n; i++ {
+		t := ft.In(i + skip)
+
+		if ft.IsVariadic() && i == n-1 {
+			for j := i; j < len(jsargs); j++ {
+				v, err := exportArg(vm, jsargs[j], t.Elem())
+				if err != nil {
+					return nil, fmt.Errorf("argument %d: %v", j+1, err)
+				}
+				args = append(args, v)
+			}
+			break
+		}
+
+		var a goja.Value
+		if i < len(jsargs) {
+			a = jsargs[i]
+		}
+
+		v, err := exportArg(vm, a, t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+		args = append(args, v)
+	}
+
+	return args, nil
+}
+
+func exportArg(vm *goja.Runtime, a goja.Value, t reflect.Type) (reflect.Value, error) {
+
+	if a == nil || goja.IsUndefined(a) || goja.IsNull(a) {
+		return reflect.Zero(t), nil
+	}
+
+	p := reflect.New(t)
+	err := vm.ExportTo(a, p.Interface())
+	return p.Elem(), err
+}
+
+// (result, error), (error), or (result)
+func splitResult(out []reflect.Value) (interface{}, error) {
+	var res interface{}
+	var err error
+
+	for _, v := range out {
+		if v.Type() == errorType {
+			if !v.IsNil() {
+				err = v.Interface().(error)
+			}
+			continue
+		}
+		res = v.Interface()
+	}
+
+	return res, err
+}
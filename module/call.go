@@ -0,0 +1,65 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 16:10 (EDT)
+// Function: record of network calls, for the run report
+
+package module
+
+import (
+	"time"
+)
+
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// modules do:
+//
+//	call := m.as.Call("std/web", method, url)
+//	defer call.Done()
+//	...
+//	call.Result(code, err)
+type Call struct {
+	Module   string        `json:"module"`
+	Method   string        `json:"method"`
+	Dest     string        `json:"dest"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Outcome  string        `json:"outcome"`
+	Code     int           `json:"code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	DryRun   bool          `json:"dry_run"`
+}
+
+func NewCall(mod, method, dest string, dryrun bool) *Call {
+	return &Call{
+		Module: mod,
+		Method: method,
+		Dest:   dest,
+		Start:  time.Now(),
+		DryRun: dryrun,
+	}
+}
+
+// record the outcome
+func (c *Call) Result(code int, err error) {
+	c.Code = code
+
+	switch {
+	case err != nil:
+		c.Outcome = OutcomeError
+		c.Error = err.Error()
+	case code >= 400:
+		c.Outcome = OutcomeError
+	default:
+		c.Outcome = OutcomeOK
+	}
+}
+
+func (c *Call) Done() {
+	c.Duration = time.Since(c.Start)
+	if c.Outcome == "" {
+		c.Outcome = OutcomeOK
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 19:40 (EDT)
+// Function: record + replay network interactions
+
+package module
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// a cassette either records every network interaction made by a script,
+// or replays a previous recording, so the script can be re-run exactly as it ran.
+// http based modules get this for free through MASer.HTTPClient/Transport,
+// other modules use Replay + Record directly:
+//
+//	if cas := m.as.Cassette(); cas.Replaying() {
+//	    it, err := cas.Replay(module.KindSMTP, "SEND", dest)
+//	    ...
+//	}
+//
+// credentials are redacted:
+//   - fields such as token, client_secret, and password in form encoded request bodies
+//   - request headers in redactHeaders, RedactHeaders, and those marked by the module
+//     with WithRedactedHeaders (eg. signatures), and Set-Cookie in responses
+//   - the bodies of requests marked with WithRedactedBodies (eg. oauth2 token requests),
+//     or to urls starting with one of RedactURLs. json responses keep their shape,
+//     with the token fields redacted, so they can still be replayed
+//
+// other bodies are recorded as sent and received
+type Cassette struct {
+	RedactHeaders []string `json:"-"` // in addition to the defaults
+	RedactURLs    []string `json:"-"` // prefixes. the request + response bodies are redacted
+
+	lock         sync.Mutex
+	replay       bool
+	used         []bool
+	Interactions []*Interaction `json:"interactions"`
+}
+
+const (
+	KindHTTP = "http"
+	KindSMTP = "smtp"
+	KindTLS  = "tls"
+)
+
+type Interaction struct {
+	Kind      string      `json:"kind"`
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	ReqHeader http.Header `json:"request_header,omitempty"`
+	ReqBody   string      `json:"request_body,omitempty"`
+	Code      int         `json:"code"`
+	Status    string      `json:"status,omitempty"`
+	Header    http.Header `json:"header,omitempty"`
+	Body      string      `json:"body,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// no recorded interaction matches the request
+type ReplayError struct {
+	Method string
+	URL    string
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("replay: no recorded response for %s %s", e.Method, e.URL)
+}
+
+var redactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+	"X-Api-Key", "Api-Key", "X-Auth-Token", "X-Amz-Security-Token",
+	"X-Hub-Signature", "X-Hub-Signature-256", "Stripe-Signature", "X-Slack-Signature", "X-Signature"}
+
+// fields of a json body that are redacted, in a redacted interaction
+var redactFields = []string{"access_token", "refresh_token", "id_token", "client_secret", "password", "assertion"}
+
+// fields of a form posted in any request that are redacted, eg. ext/slack sends token=
+var redactFormFields = []string{"token", "access_token", "refresh_token", "client_secret", "client_assertion",
+	"password", "code", "assertion"}
+
+const redacted = "REDACTED"
+
+// a new, empty, cassette for recording
+func NewCassette() *Cassette {
+	return &Cassette{}
+}
+
+// load a recording, for replay
+func LoadCassette(file string) (*Cassette, error) {
+
+	js, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cassette{replay: true}
+	err = json.Unmarshal(js, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassette '%s': %v", file, err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+// save the recording
+func (c *Cassette) Save(file string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	js, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, js, 0600)
+}
+
+func (c *Cassette) Replaying() bool {
+	return c != nil && c.replay
+}
+
+func (c *Cassette) Recording() bool {
+	return c != nil && !c.replay
+}
+
+func (c *Cassette) Record(it *Interaction) {
+	if !c.Recording() {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Interactions = append(c.Interactions, it)
+}
+
+// the next unused interaction matching the request, in recorded order
+func (c *Cassette) Replay(kind, method, url string) (*Interaction, error) {
+	if !c.Replaying() {
+		return nil, &ReplayError{method, url}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, it := range c.Interactions {
+		if c.used[i] || it.Kind != kind || it.Method != method || it.URL != url {
+			continue
+		}
+		c.used[i] = true
+		return it, nil
+	}
+
+	return nil, &ReplayError{method, url}
+}
+
+// wrap the transport to record or replay. a nil cassette does nothing.
+// at most max bytes (+1, so the reader can tell it was truncated) of a response are read
+func (c *Cassette) Transport(rt http.RoundTripper, max int64) http.RoundTripper {
+	if c == nil {
+		return rt
+	}
+	return &cassetteTransport{c, rt, max}
+}
+
+type redactKey struct{}
+
+type redaction struct {
+	headers []string
+	bodies  bool
+}
+
+// the request headers contain credentials, and are not recorded
+func WithRedactedHeaders(ctx context.Context, names ...string) context.Context {
+	r := redactFrom(ctx)
+	r.headers = append(append([]string(nil), r.headers...), names...)
+	return context.WithValue(ctx, redactKey{}, &r)
+}
+
+// the request + response bodies contain credentials, and are not recorded
+func WithRedactedBodies(ctx context.Context) context.Context {
+	r := redactFrom(ctx)
+	r.bodies = true
+	return context.WithValue(ctx, redactKey{}, &r)
+}
+
+// a copy
+func redactFrom(ctx context.Context) redaction {
+	if r, ok := ctx.Value(redactKey{}).(*redaction); ok {
+		return *r
+	}
+	return redaction{}
+}
+
+// ################################################################
+
+type cassetteTransport struct {
+	c   *Cassette
+	rt  http.RoundTripper
+	max int64
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if t.c.replay {
+		return t.replay(req)
+	}
+
+	red := redactFrom(req.Context())
+	red.headers = append(red.headers, t.c.RedactHeaders...)
+	dest := req.URL.String()
+	for _, u := range t.c.RedactURLs {
+		if strings.HasPrefix(dest, u) {
+			red.bodies = true
+		}
+	}
+
+	it := &Interaction{
+		Kind:      KindHTTP,
+		Method:    req.Method,
+		URL:       dest,
+		ReqHeader: redactHeader(req.Header, red.headers),
+	}
+
+	if red.bodies {
+		if req.Body != nil && req.Body != http.NoBody {
+			it.ReqBody = redacted
+		}
+	} else if req.GetBody != nil {
+		if b, err := req.GetBody(); err == nil {
+			body, _ := ioutil.ReadAll(io.LimitReader(b, t.max))
+			b.Close()
+			it.ReqBody = redactForm(req.Header.Get("Content-Type"), body)
+		}
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		it.Error = err.Error()
+		t.c.Record(it)
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, t.max+1))
+	resp.Body.Close()
+	if err != nil {
+		it.Error = err.Error()
+		t.c.Record(it)
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	it.Code = resp.StatusCode
+	it.Status = resp.Status
+	it.Header = redactHeader(resp.Header, red.headers)
+	it.Body = string(body)
+	if red.bodies {
+		it.Body = redactBody(body)
+	}
+	t.c.Record(it)
+
+	return resp, nil
+}
+
+func (t *cassetteTransport) replay(req *http.Request) (*http.Response, error) {
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	it, err := t.c.Replay(KindHTTP, req.Method, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	if it.Error != "" {
+		return nil, fmt.Errorf("%s", it.Error)
+	}
+	hdr := it.Header
+	if hdr == nil {
+		hdr = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        it.Status,
+		StatusCode:    it.Code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        hdr,
+		Body:          ioutil.NopCloser(strings.NewReader(it.Body)),
+		ContentLength: int64(len(it.Body)),
+		Request:       req,
+	}, nil
+}
+
+func redactHeader(h http.Header, more []string) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+
+	r := h.Clone()
+	for _, names := range [][]string{redactHeaders, more} {
+		for _, k := range names {
+			k = http.CanonicalHeaderKey(k)
+			if _, ok := r[k]; ok {
+				r[k] = []string{redacted}
+			}
+		}
+	}
+	return r
+}
+
+// redact the credentials in a form encoded body, the form keeps its other fields.
+// anything else is recorded as is
+func redactForm(ctype string, body []byte) string {
+
+	if mt, _, _ := mime.ParseMediaType(ctype); mt != "application/x-www-form-urlencoded" {
+		return string(body)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return redacted
+	}
+
+	changed := false
+	for _, f := range redactFormFields {
+		if _, ok := form[f]; ok {
+			form[f] = []string{redacted}
+			changed = true
+		}
+	}
+	if !changed {
+		return string(body)
+	}
+	return form.Encode()
+}
+
+// a json object keeps its other fields, so it can still be replayed. anything else is redacted
+func redactBody(body []byte) string {
+
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) != nil {
+		return redacted
+	}
+	for _, f := range redactFields {
+		if _, ok := obj[f]; ok {
+			obj[f] = redacted
+		}
+	}
+	js, _ := json.Marshal(obj)
+	return string(js)
+}
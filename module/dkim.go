@@ -0,0 +1,285 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 03:10 (EDT)
+// Function: dkim signing of outgoing mail
+
+package module
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dkim keys are configured by the host, per sender domain.
+// mail sent by std/smtp from a configured domain is signed automatically (rfc 6376, 8463).
+// the public key is published in dns at selector._domainkey.domain
+type DKIMKey struct {
+	Selector         string
+	PrivateKeyPEM    string   // rsa or ed25519
+	PrivateKeyFile   string   //
+	Headers          []string // to sign. default DKIMHeaders, those present in the message
+	Canonicalization string   // header/body, simple or relaxed. default relaxed/relaxed
+
+	once   sync.Once
+	signer crypto.Signer
+	err    error
+}
+
+type DKIMKeys map[string]*DKIMKey // sender domain => key
+
+// signed by default
+var DKIMHeaders = []string{"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+const (
+	dkimSimple  = "simple"
+	dkimRelaxed = "relaxed"
+)
+
+// the key for the domain, or nil
+func (ks DKIMKeys) Get(domain string) *DKIMKey {
+	for d, k := range ks {
+		if strings.EqualFold(d, domain) {
+			return k
+		}
+	}
+	return nil
+}
+
+// the private key, parsed once
+func (k *DKIMKey) Signer() (crypto.Signer, error) {
+	k.once.Do(func() {
+		k.signer, k.err = parsePrivateKey(k.PrivateKeyPEM, k.PrivateKeyFile)
+	})
+	return k.signer, k.err
+}
+
+// the message, with a DKIM-Signature header added
+func (k *DKIMKey) Sign(domain string, msg []byte, now time.Time) ([]byte, error) {
+
+	signer, err := k.Signer()
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %v", err)
+	}
+
+	var algo string
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algo = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("dkim: unsupported private key")
+	}
+
+	hcanon, bcanon, err := parseCanonicalization(k.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	hdrs, body := splitMessage(msg)
+
+	// which headers, in the order signed
+	names := k.Headers
+	if len(names) == 0 {
+		names = DKIMHeaders
+	}
+	var signed []string
+	var hdata bytes.Buffer
+	used := make(map[int]bool)
+
+	for _, name := range names {
+		// the last unused instance of the header
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headerName(hdrs[i]), name) {
+				continue
+			}
+			used[i] = true
+			signed = append(signed, name)
+			hdata.WriteString(canonHeader(hcanon, hdrs[i]))
+			break
+		}
+	}
+	if !containsFold(signed, "from") {
+		return nil, fmt.Errorf("dkim: the From header must be signed")
+	}
+
+	bh := sha256.Sum256(canonBody(bcanon, body))
+
+	sig := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=%s/%s; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		algo, hcanon, bcanon, domain, k.Selector, now.Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bh[:]))
+
+	// the signature header is included, with an empty b=, and without the final crlf
+	hdata.WriteString(strings.TrimSuffix(canonHeader(hcanon, sig), "\r\n"))
+	hh := sha256.Sum256(hdata.Bytes())
+
+	var b []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		b, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hh[:])
+	case ed25519.PrivateKey:
+		b = ed25519.Sign(key, hh[:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %v", err)
+	}
+
+	res := bytes.NewBufferString(sig)
+	res.WriteString(foldBase64(base64.StdEncoding.EncodeToString(b)))
+	res.WriteString("\r\n")
+	res.Write(msg)
+	return res.Bytes(), nil
+}
+
+// ################################################################
+
+// "header/body", or just "header", in which case the body is simple
+func parseCanonicalization(c string) (string, string, error) {
+
+	if c == "" {
+		return dkimRelaxed, dkimRelaxed, nil
+	}
+
+	p := strings.SplitN(strings.ToLower(c), "/", 2)
+	if len(p) == 1 {
+		p = append(p, dkimSimple)
+	}
+	for _, v := range p {
+		if v != dkimSimple && v != dkimRelaxed {
+			return "", "", fmt.Errorf("dkim: invalid canonicalization '%s'", c)
+		}
+	}
+	return p[0], p[1], nil
+}
+
+// the header fields, each with any continuation lines, and crlf. and the body
+func splitMessage(msg []byte) ([]string, []byte) {
+
+	var hdrs []string
+
+	for len(msg) != 0 {
+		i := bytes.Index(msg, []byte("\r\n"))
+		if i == -1 {
+			// no body
+			hdrs = appendHeaderLine(hdrs, string(msg)+"\r\n")
+			return hdrs, nil
+		}
+		line := string(msg[:i+2])
+		msg = msg[i+2:]
+		if line == "\r\n" {
+			break
+		}
+		hdrs = appendHeaderLine(hdrs, line)
+	}
+
+	return hdrs, msg
+}
+
+func appendHeaderLine(hdrs []string, line string) []string {
+	if len(hdrs) != 0 && (line[0] == ' ' || line[0] == '\t') {
+		// continuation
+		hdrs[len(hdrs)-1] += line
+		return hdrs
+	}
+	return append(hdrs, line)
+}
+
+func headerName(h string) string {
+	i := strings.IndexByte(h, ':')
+	if i == -1 {
+		return ""
+	}
+	return strings.TrimSpace(h[:i])
+}
+
+// rfc 6376 3.4.1, 3.4.2
+func canonHeader(canon, h string) string {
+
+	if canon == dkimSimple {
+		return h
+	}
+
+	i := strings.IndexByte(h, ':')
+	if i == -1 {
+		return h
+	}
+	name := strings.ToLower(strings.TrimSpace(h[:i]))
+	value := strings.NewReplacer("\r\n", "").Replace(h[i+1:])
+	value = strings.TrimSpace(compressWSP(value))
+
+	return name + ":" + value + "\r\n"
+}
+
+// rfc 6376 3.4.3, 3.4.4
+func canonBody(canon string, body []byte) []byte {
+
+	lines := strings.Split(string(body), "\r\n")
+	// the final crlf leaves an empty element
+	if len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if canon == dkimRelaxed {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(compressWSP(l), " ")
+		}
+	}
+
+	// ignore empty lines at the end
+	for len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canon == dkimSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// runs of spaces and tabs become a single space
+func compressWSP(s string) string {
+
+	var b strings.Builder
+	wsp := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == ' ' || c == '\t' {
+			wsp = true
+			continue
+		}
+		if wsp {
+			b.WriteByte(' ')
+			wsp = false
+		}
+		b.WriteByte(c)
+	}
+	if wsp {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func foldBase64(s string) string {
+
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString(s[:72])
+		b.WriteString("\r\n\t")
+		s = s[72:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
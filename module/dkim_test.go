@@ -0,0 +1,278 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 03:40 (EDT)
+// Function: dkim tests
+
+package module
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc 6376 3.4.5
+func TestDKIMCanonicalization(t *testing.T) {
+
+	hdrs, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+
+	if h := canonHeader(dkimRelaxed, hdrs[0]) + canonHeader(dkimRelaxed, hdrs[1]); h != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed header %q", h)
+	}
+	if h := canonHeader(dkimSimple, hdrs[0]) + canonHeader(dkimSimple, hdrs[1]); h != "A: X\r\nB : Y\t\r\n\tZ  \r\n" {
+		t.Errorf("simple header %q", h)
+	}
+	if b := string(canonBody(dkimRelaxed, body)); b != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body %q", b)
+	}
+	if b := string(canonBody(dkimSimple, body)); b != " C \r\nD \t E\r\n" {
+		t.Errorf("simple body %q", b)
+	}
+
+	// rfc 6376 3.4.3, 3.4.4 - the well known hashes of an empty body
+	empty := map[string]string{
+		dkimSimple:  "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY=",
+		dkimRelaxed: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+	}
+	for c, exp := range empty {
+		h := sha256.Sum256(canonBody(c, nil))
+		if b := base64.StdEncoding.EncodeToString(h[:]); b != exp {
+			t.Errorf("%s empty body %s", c, b)
+		}
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+
+	rsakey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edkey, _ := ed25519.GenerateKey(rand.Reader)
+
+	msg := "From: Bob <bob@example.com>\r\n" +
+		"To: alice@example.net\r\n" +
+		"Subject:   hello \t there\r\n" +
+		"Content-Type: multipart/mixed;\r\n\tboundary=\"xyz\"\r\n" +
+		"X-Other: not signed\r\n" +
+		"\r\n" +
+		"hi  there \r\n\r\n"
+
+	tests := []struct {
+		key   crypto.Signer
+		canon string
+	}{
+		{rsakey, ""},
+		{rsakey, "simple/simple"},
+		{edkey, "relaxed/simple"},
+		{edkey, "relaxed"},
+	}
+
+	for _, test := range tests {
+		k := &DKIMKey{Selector: "sel", PrivateKeyPEM: pemKey(test.key), Canonicalization: test.canon}
+
+		signed, err := k.Sign("example.com", []byte(msg), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := fmt.Sprintf("%T %s", test.key, test.canon)
+
+		if err := dkimVerify(signed, test.key.Public()); err != nil {
+			t.Errorf("%s: %v\n%s", desc, err, signed)
+		}
+		if !strings.Contains(string(signed), "h=From:Subject:To:Content-Type;") {
+			t.Errorf("%s: signed headers\n%s", desc, signed)
+		}
+
+		// changes are detected
+		tampered := strings.Replace(string(signed), "hello", "HELLO", 1)
+		if err := dkimVerify([]byte(tampered), test.key.Public()); err == nil {
+			t.Errorf("%s: changed header not detected", desc)
+		}
+		tampered = strings.Replace(string(signed), "hi  there", "hi  where", 1)
+		if err := dkimVerify([]byte(tampered), test.key.Public()); err == nil {
+			t.Errorf("%s: changed body not detected", desc)
+		}
+	}
+
+	// relaxed tolerates whitespace changes in transit
+	k := &DKIMKey{Selector: "sel", PrivateKeyPEM: pemKey(rsakey)}
+	signed, _ := k.Sign("example.com", []byte(msg), time.Now())
+	relayed := strings.Replace(string(signed), "hi  there \r\n", "hi there\r\n", 1)
+	if err := dkimVerify([]byte(relayed), rsakey.Public()); err != nil {
+		t.Errorf("relaxed: %v", err)
+	}
+
+	k = &DKIMKey{Selector: "sel", PrivateKeyPEM: pemKey(rsakey), Headers: []string{"Subject"}}
+	if _, err := k.Sign("example.com", []byte(msg), time.Now()); err == nil {
+		t.Errorf("expected error, From not signed")
+	}
+	k = &DKIMKey{Selector: "sel", PrivateKeyPEM: pemKey(rsakey), Canonicalization: "loose"}
+	if _, err := k.Sign("example.com", []byte(msg), time.Now()); err == nil {
+		t.Errorf("expected invalid canonicalization")
+	}
+
+	keys := DKIMKeys{"Example.COM": k}
+	if keys.Get("example.com") != k || keys.Get("example.net") != nil {
+		t.Errorf("get")
+	}
+}
+
+func pemKey(key crypto.Signer) string {
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// rfc 8463 appendix a
+const (
+	rfc8463Key = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	rfc8463Msg = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+		"From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+)
+
+// a message signed by someone else
+func TestDKIMVector(t *testing.T) {
+
+	key, _ := base64.StdEncoding.DecodeString(rfc8463Key)
+	if err := dkimVerify([]byte(rfc8463Msg), ed25519.PublicKey(key)); err != nil {
+		t.Fatalf("verifier: %v", err)
+	}
+
+	// and our canonicalization agrees
+	_, body := splitMessage([]byte(rfc8463Msg))
+	bh := sha256.Sum256(canonBody(dkimRelaxed, body))
+	if b := base64.StdEncoding.EncodeToString(bh[:]); b != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+		t.Errorf("body hash %s", b)
+	}
+}
+
+// verify the first DKIM-Signature, as a receiver would.
+// written independently of the signing code (and checked against rfc 8463), so bugs do not cancel out
+func dkimVerify(msg []byte, pub crypto.PublicKey) error {
+
+	i := strings.Index(string(msg), "\r\n\r\n")
+	if i == -1 {
+		return fmt.Errorf("no body")
+	}
+	body := string(msg[i+4:])
+
+	// unfolded, but with the original line breaks
+	var hdrs []string
+	for _, line := range strings.SplitAfter(string(msg[:i+2]), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			hdrs[len(hdrs)-1] += line
+		} else {
+			hdrs = append(hdrs, line)
+		}
+	}
+	if !strings.HasPrefix(hdrs[0], "DKIM-Signature:") {
+		return fmt.Errorf("not signed")
+	}
+	sighdr := hdrs[0]
+	hdrs = hdrs[1:]
+
+	wsp := regexp.MustCompile(`\s+`)
+	tags := map[string]string{}
+	for _, tv := range strings.Split(sighdr[len("DKIM-Signature:"):], ";") {
+		p := strings.SplitN(tv, "=", 2)
+		if len(p) == 2 {
+			tags[strings.TrimSpace(p[0])] = wsp.ReplaceAllString(p[1], "")
+		}
+	}
+	c := strings.Split(tags["c"], "/")
+	if len(c) == 1 {
+		c = append(c, "simple")
+	}
+
+	if bh := sha256.Sum256([]byte(vCanonBody(c[1], body))); base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return fmt.Errorf("body hash mismatch")
+	}
+
+	// rfc 6376 5.4.2 - the last unused instance of each, in order. missing ones are skipped
+	var data strings.Builder
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(strings.TrimSpace(strings.SplitN(hdrs[i], ":", 2)[0]), name) {
+				used[i] = true
+				data.WriteString(vCanonHeader(c[0], hdrs[i]))
+				break
+			}
+		}
+	}
+	// with the b= value removed, and no trailing crlf
+	unsigned := regexp.MustCompile(`(;\s*b=)[A-Za-z0-9+/=\s]*(;|\r\n$)`).ReplaceAllString(sighdr, "$1$2")
+	data.WriteString(strings.TrimSuffix(vCanonHeader(c[0], unsigned), "\r\n"))
+	hh := sha256.Sum256([]byte(data.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return fmt.Errorf("algorithm %s", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hh[:], sig)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return fmt.Errorf("algorithm %s", tags["a"])
+		}
+		if !ed25519.Verify(pub, hh[:], sig) {
+			return fmt.Errorf("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key")
+}
+
+// rfc 6376 3.4.1, 3.4.2
+func vCanonHeader(canon, h string) string {
+	if canon == "simple" {
+		return h
+	}
+	p := strings.SplitN(h, ":", 2)
+	value := regexp.MustCompile(`[ \t]+`).ReplaceAllString(strings.Replace(p[1], "\r\n", "", -1), " ")
+	return strings.ToLower(strings.TrimSpace(p[0])) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// rfc 6376 3.4.3, 3.4.4
+func vCanonBody(canon, body string) string {
+	if canon == "relaxed" {
+		body = regexp.MustCompile(`[ \t]+`).ReplaceAllString(body, " ")
+		body = strings.Replace(body, " \r\n", "\r\n", -1)
+	}
+	body = regexp.MustCompile(`(\r\n)*$`).ReplaceAllString(body, "")
+	if body == "" && canon == "relaxed" {
+		return ""
+	}
+	return body + "\r\n"
+}
@@ -21,11 +21,13 @@ const (
 var _ = module.Register("ext/mailchimp", install)
 
 type mod struct {
-	as module.MASer
+	as        module.MASer
+	SendAsync func(goja.FunctionCall) goja.Value `json:"send_async"`
 }
 
 func install(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &mod{aser}
+	m := &mod{as: aser}
+	m.SendAsync = module.Async(aser, vm, m.Send)
 	return m
 }
 
@@ -23,11 +23,17 @@ const s3Host = "s3.amazonaws.com"
 var _ = module.Register("ext/s3", install)
 
 type mod struct {
-	as module.MASer
+	as          module.MASer
+	PutAsync    func(goja.FunctionCall) goja.Value `json:"put_async"`
+	GetAsync    func(goja.FunctionCall) goja.Value `json:"get_async"`
+	RemoveAsync func(goja.FunctionCall) goja.Value `json:"remove_async"`
 }
 
 func install(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &mod{aser}
+	m := &mod{as: aser}
+	m.PutAsync = module.Async(aser, vm, m.Put)
+	m.GetAsync = module.Async(aser, vm, m.Get)
+	m.RemoveAsync = module.Async(aser, vm, m.Remove)
 	return m
 }
 
@@ -66,11 +72,15 @@ func (m *mod) Put(creds *Creds, bucket string, key string, data string, opts *Pu
 		return nil, fmt.Errorf("s3.put where?")
 	}
 
+	call := m.as.Call("ext/s3", "put", bucket+"/"+key)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -78,6 +88,9 @@ func (m *mod) Put(creds *Creds, bucket string, key string, data string, opts *Pu
 	// for debugging
 	m.as.Diagf("s3/put bucket %s, key %s", bucket, key)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			return m.mocked(call, mk)
+		}
 		return &Result{ETag: "1", Version: "dry-run"}, nil
 	}
 
@@ -86,18 +99,21 @@ func (m *mod) Put(creds *Creds, bucket string, key string, data string, opts *Pu
 	}
 
 	client, err := minio.New(creds.Hostname, &minio.Options{
-		Creds:  credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
-		Secure: true,
-		Region: creds.Region,
+		Creds:     credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
+		Secure:    true,
+		Region:    creds.Region,
+		Transport: m.as.Transport(),
 	})
 
 	if err != nil {
+		call.Result(0, err)
 		return nil, fmt.Errorf("s3 client failed: %v", err)
 	}
 
 	//client.SetAppInfo("myCloudApp", "1.0.0")
 
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
+	ctx, cancel := context.WithTimeout(m.as.Context(), m.as.NetTimeout())
+	defer cancel()
 	b := bytes.NewBufferString(data)
 
 	putOpts := minio.PutObjectOptions{
@@ -115,6 +131,7 @@ func (m *mod) Put(creds *Creds, bucket string, key string, data string, opts *Pu
 	info, err := client.PutObject(ctx, bucket, key, b, int64(len(data)), putOpts)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("s3 error %v", err)
 		return nil, fmt.Errorf("s3.put failed: %v", err)
@@ -132,11 +149,15 @@ func (m *mod) Get(creds *Creds, bucket string, key string) (*Result, error) {
 		return nil, fmt.Errorf("s3.put where?")
 	}
 
+	call := m.as.Call("ext/s3", "get", bucket+"/"+key)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -144,6 +165,9 @@ func (m *mod) Get(creds *Creds, bucket string, key string) (*Result, error) {
 	// for debugging
 	m.as.Diagf("s3/get bucket %s, key %s", bucket, key)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			return m.mocked(call, mk)
+		}
 		return &Result{}, nil
 	}
 
@@ -152,20 +176,24 @@ func (m *mod) Get(creds *Creds, bucket string, key string) (*Result, error) {
 	}
 
 	client, err := minio.New(creds.Hostname, &minio.Options{
-		Creds:  credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
-		Secure: true,
-		Region: creds.Region,
+		Creds:     credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
+		Secure:    true,
+		Region:    creds.Region,
+		Transport: m.as.Transport(),
 	})
 
 	if err != nil {
+		call.Result(0, err)
 		return nil, fmt.Errorf("s3 client failed: %v", err)
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
+	ctx, cancel := context.WithTimeout(m.as.Context(), m.as.NetTimeout())
+	defer cancel()
 
 	obj, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("s3 error %v", err)
 		return nil, fmt.Errorf("s3.get failed: %v", err)
@@ -193,11 +221,15 @@ func (m *mod) Remove(creds *Creds, bucket string, key string, version string) (*
 		return nil, fmt.Errorf("s3.put where?")
 	}
 
+	call := m.as.Call("ext/s3", "remove", bucket+"/"+key)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -205,6 +237,9 @@ func (m *mod) Remove(creds *Creds, bucket string, key string, version string) (*
 	// for debugging
 	m.as.Diagf("s3/put bucket %s, key %s", bucket, key)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			return m.mocked(call, mk)
+		}
 		return &Result{ETag: "1", Version: "dry-run"}, nil
 	}
 
@@ -213,22 +248,26 @@ func (m *mod) Remove(creds *Creds, bucket string, key string, version string) (*
 	}
 
 	client, err := minio.New(creds.Hostname, &minio.Options{
-		Creds:  credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
-		Secure: true,
-		Region: creds.Region,
+		Creds:     credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
+		Secure:    true,
+		Region:    creds.Region,
+		Transport: m.as.Transport(),
 	})
 
 	if err != nil {
+		call.Result(0, err)
 		return nil, fmt.Errorf("s3 client failed: %v", err)
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
+	ctx, cancel := context.WithTimeout(m.as.Context(), m.as.NetTimeout())
+	defer cancel()
 
 	err = client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{
 		VersionID: version},
 	)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("s3 error %v", err)
 		return nil, fmt.Errorf("s3.put failed: %v", err)
@@ -243,11 +282,15 @@ func (m *mod) Newbucket(creds *Creds, bucket string) error {
 		return fmt.Errorf("s3.put where?")
 	}
 
+	call := m.as.Call("ext/s3", "newbucket", bucket)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return err
 	}
@@ -255,6 +298,10 @@ func (m *mod) Newbucket(creds *Creds, bucket string) error {
 	// for debugging
 	m.as.Diagf("s3/new bucket %s", bucket)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			_, err := m.mocked(call, mk)
+			return err
+		}
 		return nil
 	}
 
@@ -263,16 +310,19 @@ func (m *mod) Newbucket(creds *Creds, bucket string) error {
 	}
 
 	client, err := minio.New(creds.Hostname, &minio.Options{
-		Creds:  credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
-		Secure: true,
-		Region: creds.Region,
+		Creds:     credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
+		Secure:    true,
+		Region:    creds.Region,
+		Transport: m.as.Transport(),
 	})
 
 	if err != nil {
+		call.Result(0, err)
 		return fmt.Errorf("s3 client failed: %v", err)
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
+	ctx, cancel := context.WithTimeout(m.as.Context(), m.as.NetTimeout())
+	defer cancel()
 	err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: creds.Region})
 	if err != nil {
 		// Check to see if we already own this bucket (which happens if you run this twice)
@@ -281,6 +331,7 @@ func (m *mod) Newbucket(creds *Creds, bucket string) error {
 			return nil
 			m.as.Diagf("s3/new bucket %s - already exists", bucket)
 		} else {
+			call.Result(500, err)
 			return fmt.Errorf("s3/newbucket failed: %v", err)
 		}
 	}
@@ -288,3 +339,16 @@ func (m *mod) Newbucket(creds *Creds, bucket string) error {
 	m.as.Diagf("s3/new bucket %s - created", bucket)
 	return nil
 }
+
+// simulate the response, for a dry run
+func (m *mod) mocked(call *module.Call, mk *module.Mock) (*Result, error) {
+
+	if err := mk.Failure(); err != nil {
+		call.Result(mk.StatusCode(), err)
+		m.as.NetIOErr()
+		m.as.Logf("s3 error %v", err)
+		return nil, fmt.Errorf("s3.%s failed: %v", call.Method, err)
+	}
+
+	return &Result{Content: mk.Body, Header: mk.Header, ETag: "1", Version: "dry-run"}, nil
+}
@@ -6,12 +6,13 @@
 package modtsendgrid
 
 import (
-	"context"
 	"encoding/base64"
 	"fmt"
+	netmail "net/mail"
 
 	"github.com/jaw0/go-alertscript/module"
 	"github.com/dop251/goja"
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 
@@ -21,7 +22,9 @@ import (
 var _ = module.Register("ext/sendgrid", install)
 
 type mod struct {
-	as module.MASer
+	as        module.MASer
+	SendAsync func(goja.FunctionCall) goja.Value `json:"send_async"`
+	Retry     *module.RetryPolicy                `json:"retry"` // set by the script
 }
 
 type Result struct {
@@ -32,10 +35,18 @@ type Result struct {
 }
 
 func install(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &mod{aser}
+	m := &mod{as: aser}
+	m.SendAsync = module.AsyncWith(aser, vm, m.snapshot, (*mod).Send)
 	return m
 }
 
+// a copy of the settings, for a request running in the background
+func (m *mod) snapshot() interface{} {
+	c := *m
+	c.Retry = m.Retry.Clone()
+	return &c
+}
+
 // optionally pass in something resembling a SGMailV3
 func (m *mod) Send(key string, msg *modstd.SmtpMsg, sgm *mail.SGMailV3) (*Result, error) {
 
@@ -43,18 +54,41 @@ func (m *mod) Send(key string, msg *modstd.SmtpMsg, sgm *mail.SGMailV3) (*Result
 		return nil, fmt.Errorf("sendgrid.send(key, message)")
 	}
 
+	to, cc, bcc, err := msg.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("sendgrid: no recipients")
+	}
+	dest := to[0].Address
+
+	call := m.as.Call("ext/sendgrid", "send", dest)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
 
 	// for debugging
-	m.as.Diagf("sending to sendgrid %s", msg.To)
+	m.as.Diagf("sending to sendgrid %s", dest)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			if err := mk.Err(); err != nil {
+				call.Result(500, err)
+				m.as.NetIOErr()
+				m.as.Logf("sendgrid error %v", err)
+				return &Result{500, err.Error(), nil, ""}, nil
+			}
+			call.Result(mk.StatusCode(), nil)
+			return &Result{mk.StatusCode(), mk.Status(), mk.Header, mk.Body}, nil
+		}
 		return &Result{200, "dry run", nil, ""}, nil
 	}
 
@@ -65,7 +99,7 @@ func (m *mod) Send(key string, msg *modstd.SmtpMsg, sgm *mail.SGMailV3) (*Result
 	}
 
 	sgm.SetFrom(mail.NewEmail(msg.FromName, msg.From))
-	sgm.AddPersonalizations(&mail.Personalization{To: []*mail.Email{mail.NewEmail(msg.ToName, msg.To)}})
+	sgm.AddPersonalizations(&mail.Personalization{To: sgEmails(to), CC: sgEmails(cc), BCC: sgEmails(bcc)})
 	sgm.Subject = msg.Subject
 
 	if msg.Text != "" {
@@ -92,15 +126,21 @@ func (m *mod) Send(key string, msg *modstd.SmtpMsg, sgm *mail.SGMailV3) (*Result
 		}
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
-	res, err := client.SendWithContext(ctx, sgm)
+	// the transport applies NetTimeout to each attempt
+	ctx := module.WithRetry(m.as.Context(), m.Retry)
+	// the sendgrid package always uses its default http client
+	client.Body = mail.GetRequestBody(sgm)
+	rc := &rest.Client{HTTPClient: m.as.HTTPClient()}
+	res, err := rc.SendWithContext(ctx, client.Request)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("sendgrid error %v", err)
 		return &Result{500, err.Error(), nil, ""}, nil
 	}
 
+	call.Result(res.StatusCode, nil)
 	sm := "OK"
 
 	if res.StatusCode != 200 {
@@ -109,3 +149,12 @@ func (m *mod) Send(key string, msg *modstd.SmtpMsg, sgm *mail.SGMailV3) (*Result
 	return &Result{res.StatusCode, sm, res.Headers, res.Body}, nil
 
 }
+
+func sgEmails(addrs []*netmail.Address) []*mail.Email {
+
+	var res []*mail.Email
+	for _, a := range addrs {
+		res = append(res, mail.NewEmail(a.Name, a.Address))
+	}
+	return res
+}
@@ -6,8 +6,6 @@
 package modslack
 
 import (
-	"context"
-
 	"github.com/jaw0/go-alertscript/module"
 	"github.com/dop251/goja"
 	"github.com/slack-go/slack"
@@ -16,7 +14,9 @@ import (
 var _ = module.Register("ext/slack", install)
 
 type mod struct {
-	as module.MASer
+	as        module.MASer
+	PostAsync func(goja.FunctionCall) goja.Value `json:"post_async"`
+	Retry     *module.RetryPolicy                `json:"retry"` // set by the script
 }
 
 type logger struct {
@@ -29,17 +29,29 @@ type Result struct {
 }
 
 func install(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &mod{aser}
+	m := &mod{as: aser}
+	m.PostAsync = module.AsyncWith(aser, vm, m.snapshot, (*mod).Post)
 	return m
 }
 
+// a copy of the settings, for a request running in the background
+func (m *mod) snapshot() interface{} {
+	c := *m
+	c.Retry = m.Retry.Clone()
+	return &c
+}
+
 func (m *mod) Post(token string, channel string, msgs ...slack.Attachment) (*Result, error) {
 
+	call := m.as.Call("ext/slack", "post", channel)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -47,17 +59,29 @@ func (m *mod) Post(token string, channel string, msgs ...slack.Attachment) (*Res
 	// for debugging
 	m.as.Diagf("posting to slack chan %s", channel)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			if err := mk.Failure(); err != nil {
+				call.Result(mk.StatusCode(), err)
+				m.as.NetIOErr()
+				m.as.Logf("slack error %v", err)
+				return &Result{500, err.Error()}, nil
+			}
+			return &Result{mk.StatusCode(), mk.Status()}, nil
+		}
 		return &Result{200, "dry run"}, nil
 	}
 
-	client := slack.New(token, slack.OptionDebug(true), slack.OptionLog(logger{m.as}))
+	client := slack.New(token, slack.OptionDebug(true), slack.OptionLog(logger{m.as}),
+		slack.OptionHTTPClient(m.as.HTTPClient()))
 
 	// QQQ - other options?
-	ctx, _ := context.WithTimeout(context.Background(), m.as.NetTimeout())
+	// the transport applies NetTimeout to each attempt
+	ctx := module.WithRetry(m.as.Context(), m.Retry)
 	_, _, err = client.PostMessageContext(
 		ctx, channel, slack.MsgOptionAttachments(msgs...),
 	)
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("slack error %v", err)
 		return &Result{500, err.Error()}, nil
@@ -6,12 +6,15 @@
 package modtwilio
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/jaw0/go-alertscript/module"
 	"github.com/dop251/goja"
 	// these are awful. just awful. absolutely awful.
 	"github.com/twilio/twilio-go"
+	twclient "github.com/twilio/twilio-go/client"
 	openapi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
@@ -20,7 +23,10 @@ var _ = module.Register("ext/twilio", install)
 const twilioUrl = "https://api.twilio.com/"
 
 type mod struct {
-	as module.MASer
+	as           module.MASer
+	MessageAsync func(goja.FunctionCall) goja.Value `json:"message_async"`
+	PhoneAsync   func(goja.FunctionCall) goja.Value `json:"phone_async"`
+	Retry        *module.RetryPolicy                `json:"retry"` // set by the script
 }
 
 type Creds struct {
@@ -35,10 +41,19 @@ type Result struct {
 }
 
 func install(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &mod{aser}
+	m := &mod{as: aser}
+	m.MessageAsync = module.AsyncWith(aser, vm, m.snapshot, (*mod).Message)
+	m.PhoneAsync = module.AsyncWith(aser, vm, m.snapshot, (*mod).Phone)
 	return m
 }
 
+// a copy of the settings, for a request running in the background
+func (m *mod) snapshot() interface{} {
+	c := *m
+	c.Retry = m.Retry.Clone()
+	return &c
+}
+
 // optionally pass in something resembling CreateMessageParams
 func (m *mod) Message(creds *Creds, to, from string, text string, params *openapi.CreateMessageParams) (*Result, error) {
 
@@ -46,11 +61,15 @@ func (m *mod) Message(creds *Creds, to, from string, text string, params *openap
 		return nil, fmt.Errorf("must supply twilio credentials")
 	}
 
+	call := m.as.Call("ext/twilio", "message", to)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -58,13 +77,13 @@ func (m *mod) Message(creds *Creds, to, from string, text string, params *openap
 	// for debugging
 	m.as.Diagf("sending to twilio (message) %s", to)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			return m.mocked(call, mk), nil
+		}
 		return &Result{200, "dry run", nil}, nil
 	}
 
-	client := twilio.NewRestClientWithParams(twilio.RestClientParams{
-		Username: creds.SID,
-		Password: creds.Token,
-	})
+	client := m.client(creds)
 
 	if params == nil {
 		params = &openapi.CreateMessageParams{}
@@ -73,10 +92,10 @@ func (m *mod) Message(creds *Creds, to, from string, text string, params *openap
 	params.SetFrom(from)
 	params.SetBody(text)
 
-	client.SetTimeout(m.as.NetTimeout())
 	res, err := client.ApiV2010.CreateMessage(params)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("twilio error %v", err)
 		return &Result{500, err.Error(), nil}, nil
@@ -87,11 +106,19 @@ func (m *mod) Message(creds *Creds, to, from string, text string, params *openap
 
 func (m *mod) Phone(creds *Creds, to, from, url string, params *openapi.CreateCallParams) (*Result, error) {
 
+	if creds == nil {
+		return nil, fmt.Errorf("must supply twilio credentials")
+	}
+
+	call := m.as.Call("ext/twilio", "phone", to)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -99,13 +126,13 @@ func (m *mod) Phone(creds *Creds, to, from, url string, params *openapi.CreateCa
 	// for debugging
 	m.as.Diagf("sending to twilio (phone) %s", to)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			return m.mocked(call, mk), nil
+		}
 		return &Result{200, "dry run", nil}, nil
 	}
 
-	client := twilio.NewRestClientWithParams(twilio.RestClientParams{
-		Username: creds.SID,
-		Password: creds.Token,
-	})
+	client := m.client(creds)
 
 	if params == nil {
 		params = &openapi.CreateCallParams{}
@@ -114,10 +141,10 @@ func (m *mod) Phone(creds *Creds, to, from, url string, params *openapi.CreateCa
 	params.SetFrom(from)
 	params.SetUrl(url)
 
-	client.SetTimeout(m.as.NetTimeout())
 	res, err := client.ApiV2010.CreateCall(params)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("twilio error %v", err)
 		return &Result{500, err.Error(), nil}, nil
@@ -127,4 +154,42 @@ func (m *mod) Phone(creds *Creds, to, from, url string, params *openapi.CreateCa
 
 }
 
+// simulate the response, for a dry run
+func (m *mod) mocked(call *module.Call, mk *module.Mock) *Result {
+
+	if err := mk.Failure(); err != nil {
+		call.Result(mk.StatusCode(), err)
+		m.as.NetIOErr()
+		m.as.Logf("twilio error %v", err)
+		return &Result{500, err.Error(), nil}
+	}
+	return &Result{mk.StatusCode(), mk.Status(), nil}
+}
+
+// the twilio api does not take a context, so we attach it to each request
+func (m *mod) client(creds *Creds) *twilio.RestClient {
+
+	c := &twclient.Client{
+		Credentials: twclient.NewCredentials(creds.SID, creds.Token),
+		HTTPClient: &http.Client{
+			Transport: ctxTransport{module.WithRetry(m.as.Context(), m.Retry), m.as.Transport()},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	c.SetAccountSid(creds.SID)
+
+	return twilio.NewRestClientWithParams(twilio.RestClientParams{Client: c})
+}
+
+type ctxTransport struct {
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+func (t ctxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.rt.RoundTrip(req.WithContext(t.ctx))
+}
+
 // RSN - more things
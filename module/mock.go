@@ -0,0 +1,164 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 20:25 (EDT)
+// Function: simulated responses for dry runs
+
+package module
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// on a dry run, modules look for a mock matching the call, and return its response:
+//
+//	if m.as.IsDryRun() {
+//	    if mk := m.as.Mock(call); mk != nil {
+//	        ...
+//	    }
+//	    return canned result
+//	}
+//
+// the first matching mock is used. in patterns, '*' matches anything
+type Mock struct {
+	Module  string              `json:"module" yaml:"module"`   // eg. "std/web", "ext/*". empty matches any
+	Method  string              `json:"method" yaml:"method"`   // eg. "POST", "send". empty matches any
+	Dest    string              `json:"dest" yaml:"dest"`       // url, address, recipient, ... empty matches any
+	Code    int                 `json:"code" yaml:"code"`       // default 200
+	Message string              `json:"message" yaml:"message"` // default is the http status text
+	Header  map[string][]string `json:"header" yaml:"header"`
+	Body    string              `json:"body" yaml:"body"`
+	Latency Duration            `json:"latency" yaml:"latency"` // eg. "250ms"
+	Error   string              `json:"error" yaml:"error"`     // simulate a network error
+}
+
+type Mocks []*Mock
+
+// a duration, as "1.5s" in json + yaml
+type Duration time.Duration
+
+// load mocks from a json or yaml file (a list of mocks)
+func LoadMocks(file string) (Mocks, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms Mocks
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &ms)
+	default:
+		err = json.Unmarshal(data, &ms)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid mocks '%s': %v", file, err)
+	}
+
+	return ms, nil
+}
+
+// the first mock matching the call
+func (ms Mocks) Find(c *Call) *Mock {
+	for _, mk := range ms {
+		if mk.matches(c) {
+			return mk
+		}
+	}
+	return nil
+}
+
+// the simulated network error, if any
+func (mk *Mock) Err() error {
+	if mk.Error == "" {
+		return nil
+	}
+	return errors.New(mk.Error)
+}
+
+// the simulated network error, or an error for a failing status code.
+// for modules that do not return a status code
+func (mk *Mock) Failure() error {
+	if err := mk.Err(); err != nil {
+		return err
+	}
+	if mk.StatusCode() >= 400 {
+		return fmt.Errorf("%d %s", mk.StatusCode(), mk.Status())
+	}
+	return nil
+}
+
+func (mk *Mock) StatusCode() int {
+	if mk.Code == 0 {
+		return 200
+	}
+	return mk.Code
+}
+
+func (mk *Mock) Status() string {
+	if mk.Message == "" {
+		return http.StatusText(mk.StatusCode())
+	}
+	return mk.Message
+}
+
+func (mk *Mock) matches(c *Call) bool {
+	return globMatch(mk.Module, c.Module) &&
+		(mk.Method == "" || strings.EqualFold(mk.Method, c.Method)) &&
+		globMatch(mk.Dest, c.Dest)
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+
+	re := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$"
+	ok, _ := regexp.MatchString(re, s)
+	return ok
+}
+
+// ################################################################
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -6,7 +6,10 @@
 package module
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dop251/goja"
@@ -25,6 +28,47 @@ type MASer interface {
 	Fatal(error)
 	TraceInfo() string
 	Federation() string
+	Context() context.Context
+	Async(func() (interface{}, error)) *goja.Promise
+	Library(string) (goja.Value, error)
+	Call(mod, method, dest string) *Call
+	CheckModule(string) error
+	NetPolicy() *NetPolicy
+	Cassette() *Cassette
+	HTTPClient() *http.Client
+	Transport() http.RoundTripper
+	TLSClient(string) (*http.Client, error)
+	SigningKey(string) (*SigningKey, error)
+	OAuth2Client(string) (*OAuth2Client, error)
+	DKIMKey(string) *DKIMKey
+	TokenCache() *TokenCache
+	Store() Store
+	Mock(*Call) *Mock
+	MaxResponseSize() int64
+}
+
+// module('lib/name') loads a javascript library
+const LibPrefix = "lib/"
+
+// module() could not find the requested module or library
+type ModuleNotFoundError struct {
+	Name string
+}
+
+func (e *ModuleNotFoundError) Error() string {
+	if strings.HasPrefix(e.Name, LibPrefix) {
+		return fmt.Sprintf("library not found: '%s'", e.Name)
+	}
+	return fmt.Sprintf("module not found: '%s'", e.Name)
+}
+
+// the module exists, but this script may not use it
+type ModuleForbiddenError struct {
+	Name string
+}
+
+func (e *ModuleForbiddenError) Error() string {
+	return fmt.Sprintf("module not permitted: '%s'", e.Name)
 }
 
 type Installer func(MASer, *goja.Runtime, []interface{}) interface{}
@@ -42,16 +86,36 @@ func Register(mod string, f Installer) bool {
 
 // add the module() function to js
 func InstallModule(as MASer, vm *goja.Runtime) {
-	vm.Set("module", func(name string, args ...interface{}) interface{} { return jsModule(as, name, vm, args) })
+	vm.Set("module", Loader(as, vm))
+}
+
+// the module() function
+func Loader(as MASer, vm *goja.Runtime) func(string, ...interface{}) interface{} {
+	return func(name string, args ...interface{}) interface{} { return jsModule(as, name, vm, args) }
 }
 
 // in js code:
 //  var foo = module("foo") or module("foo", args...)
 func jsModule(as MASer, name string, vm *goja.Runtime, args []interface{}) interface{} {
+
+	if strings.HasPrefix(name, LibPrefix) {
+		v, err := as.Library(name)
+		if err != nil {
+			as.VM().Interrupt(err)
+			return nil
+		}
+		return v
+	}
+
 	f := registry[name]
 
 	if f == nil {
-		as.VM().Interrupt(fmt.Errorf("module not fount: '%s'", name))
+		as.VM().Interrupt(&ModuleNotFoundError{Name: name})
+		return nil
+	}
+	if err := as.CheckModule(name); err != nil {
+		as.VM().Interrupt(err)
+		return nil
 	}
 
 	args = append([]interface{}{name}, args...) // add name to args
@@ -0,0 +1,349 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 18:30 (EDT)
+// Function: where scripts may connect to
+
+package module
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// modules connect using the policy's dialer or http client:
+//
+//	client := m.as.NetPolicy().HTTPClient(m.as.NetTimeout())
+//	conn, err := m.as.NetPolicy().DialContext(ctx, &net.Dialer{}, "tcp", addr)
+//
+// the hostname (or ip literal) is checked against the host patterns before connecting,
+// the address actually connected to (after dns) is checked against the cidrs.
+// redirects are checked the same way.
+// a nil policy permits everything
+type NetPolicy struct {
+	BlockPrivate bool     // deny loopback, link-local, private, cgnat, unspecified, multicast
+	AllowCIDRs   []string // if set, only these addresses, eg. "203.0.113.0/24"
+	DenyCIDRs    []string // checked first
+	AllowHosts   []string // if set, only these hosts. glob patterns, eg. "*.slack.com"
+	DenyHosts    []string // checked first
+	AllowPorts   []int    // if set, only these ports
+	AllowSchemes []string // if set, only these url schemes, eg. "https"
+
+	once      sync.Once
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	err       error
+	transport *http.Transport
+	lock      sync.Mutex
+	tlsTrans  map[*TLSProfile]*http.Transport // kept here, so they go away with the policy
+}
+
+// the connection was refused by the network policy
+type NetPolicyError struct {
+	Dest   string
+	Reason string
+}
+
+func (e *NetPolicyError) Error() string {
+	return fmt.Sprintf("destination not permitted: %s (%s)", e.Dest, e.Reason)
+}
+
+var privateNets = parseCIDRs([]string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+})
+
+// check the scheme, host, and port of a url
+func (p *NetPolicy) CheckURL(u *url.URL) error {
+	if p == nil {
+		return nil
+	}
+
+	if len(p.AllowSchemes) != 0 && !containsFold(p.AllowSchemes, u.Scheme) {
+		return &NetPolicyError{u.String(), "scheme"}
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = u.Scheme
+	}
+	return p.CheckAddr(net.JoinHostPort(u.Hostname(), port))
+}
+
+// check host:port before connecting
+func (p *NetPolicy) CheckAddr(addr string) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.init(); err != nil {
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &NetPolicyError{addr, err.Error()}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if matchHost(host, p.DenyHosts) {
+		return &NetPolicyError{addr, "host denied"}
+	}
+	if len(p.AllowHosts) != 0 && !matchHost(host, p.AllowHosts) {
+		return &NetPolicyError{addr, "host not allowed"}
+	}
+	if err := p.checkPort(addr, port); err != nil {
+		return err
+	}
+
+	// an ip literal can be checked now, instead of failing at connect
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(addr, ip)
+	}
+	return nil
+}
+
+// check a resolved address
+func (p *NetPolicy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.init(); err != nil {
+		return err
+	}
+	return p.checkIP(ip.String(), ip)
+}
+
+// a copy of the dialer, that checks the address actually connected to
+func (p *NetPolicy) Dialer(d *net.Dialer) *net.Dialer {
+	if p == nil {
+		return d
+	}
+
+	nd := *d
+	prev := d.Control
+	nd.Control = func(network, addr string, c syscall.RawConn) error {
+		if err := p.checkConn(addr); err != nil {
+			return err
+		}
+		if prev != nil {
+			return prev(network, addr, c)
+		}
+		return nil
+	}
+	return &nd
+}
+
+// check the address, then connect using the dialer
+func (p *NetPolicy) DialContext(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	if err := p.CheckAddr(addr); err != nil {
+		return nil, err
+	}
+	return p.Dialer(d).DialContext(ctx, network, addr)
+}
+
+// a transport that checks every connection
+func (p *NetPolicy) Transport() http.RoundTripper {
+	if p == nil {
+		return http.DefaultTransport
+	}
+	p.init()
+	return p.transport
+}
+
+// a new transport that checks every connection, using the tls config
+func (p *NetPolicy) TLSTransport(cf *tls.Config) *http.Transport {
+
+	var t *http.Transport
+	if p == nil {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		p.init()
+		t = p.transport.Clone()
+	}
+	t.TLSClientConfig = cf
+	return t
+}
+
+// the transport for the tls profile, made once per policy, so connections can be reused
+func (p *NetPolicy) profileTransport(tp *TLSProfile, cf *tls.Config) *http.Transport {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	t := p.tlsTrans[tp]
+	if t == nil {
+		if p.tlsTrans == nil {
+			p.tlsTrans = make(map[*TLSProfile]*http.Transport)
+		}
+		t = p.TLSTransport(cf)
+		p.tlsTrans[tp] = t
+	}
+	return t
+}
+
+// an http client that checks every connection and redirect
+func (p *NetPolicy) HTTPClient(timeout time.Duration) *http.Client {
+	if p == nil {
+		return &http.Client{Timeout: timeout}
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: p.Transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return p.CheckURL(req.URL)
+		},
+	}
+}
+
+// ################################################################
+
+func (p *NetPolicy) init() error {
+
+	p.once.Do(func() {
+		p.allow, p.err = parseCIDRList(p.AllowCIDRs)
+		if p.err == nil {
+			p.deny, p.err = parseCIDRList(p.DenyCIDRs)
+		}
+
+		// shared, so connections can be reused. a proxy would hide the real destination
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return p.DialContext(ctx, d, network, addr)
+		}
+		p.transport = t
+	})
+
+	if p.err != nil {
+		return fmt.Errorf("invalid network policy: %v", p.err)
+	}
+	return nil
+}
+
+// called by the dialer with the resolved ip:port
+func (p *NetPolicy) checkConn(addr string) error {
+	if err := p.init(); err != nil {
+		return err
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &NetPolicyError{addr, err.Error()}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &NetPolicyError{addr, "not an ip address"}
+	}
+	if err := p.checkPort(addr, port); err != nil {
+		return err
+	}
+	return p.checkIP(addr, ip)
+}
+
+func (p *NetPolicy) checkIP(dest string, ip net.IP) error {
+
+	if matchNet(ip, p.deny) {
+		return &NetPolicyError{dest, "address denied"}
+	}
+	if p.BlockPrivate && matchNet(ip, privateNets) {
+		return &NetPolicyError{dest, "private address"}
+	}
+	if len(p.allow) != 0 && !matchNet(ip, p.allow) {
+		return &NetPolicyError{dest, "address not allowed"}
+	}
+	return nil
+}
+
+func (p *NetPolicy) checkPort(dest, port string) error {
+
+	if len(p.AllowPorts) == 0 {
+		return nil
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		// a service name, eg. "https"
+		n, err = net.LookupPort("tcp", port)
+		if err != nil {
+			return &NetPolicyError{dest, "invalid port"}
+		}
+	}
+
+	for _, ap := range p.AllowPorts {
+		if ap == n {
+			return nil
+		}
+	}
+	return &NetPolicyError{dest, "port not allowed"}
+}
+
+func matchHost(host string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNet(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRList(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			// a single address
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets, err := parseCIDRList(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 19:05 (EDT)
+// Function: network policy tests
+
+package module
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestNetPolicy(t *testing.T) {
+
+	p := &NetPolicy{
+		BlockPrivate: true,
+		DenyCIDRs:    []string{"203.0.113.7"},
+		DenyHosts:    []string{"*.internal.example.com"},
+		AllowPorts:   []int{80, 443, 514},
+		AllowSchemes: []string{"https"},
+	}
+
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"www.example.com:443", true},
+		{"203.0.113.8:514", true},
+		{"203.0.113.7:514", false},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"[::ffff:10.1.2.3]:443", false},
+		{"169.254.169.254:80", false},
+		{"db.internal.example.com:443", false},
+		{"DB.Internal.Example.COM.:443", false},
+		{"www.example.com:25", false},
+	}
+
+	for _, tt := range tests {
+		err := p.CheckAddr(tt.addr)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.addr, err)
+		}
+	}
+
+	// the dialer checks the resolved address
+	if err := p.checkConn("10.0.0.1:443"); err == nil {
+		t.Errorf("dialer permitted private address")
+	}
+
+	u, _ := url.Parse("http://www.example.com/")
+	var perr *NetPolicyError
+	if err := p.CheckURL(u); !errors.As(err, &perr) || perr.Reason != "scheme" {
+		t.Errorf("scheme: %v", err)
+	}
+
+	// fail closed
+	bad := &NetPolicy{AllowCIDRs: []string{"10.0.0.0/33"}}
+	if err := bad.CheckIP(net.ParseIP("10.0.0.1")); err == nil {
+		t.Errorf("invalid policy permitted connection")
+	}
+
+	var none *NetPolicy
+	if err := none.CheckAddr("127.0.0.1:22"); err != nil {
+		t.Errorf("nil policy: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 00:10 (EDT)
+// Function: oauth2 clients + token cache
+
+package module
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// oauth2 clients are configured by the host, and selected by name from js,
+// so client secrets never appear in the script:
+//
+//	web.fetch(url, {oauth2: 'partner'})
+//	web.oauth2 = 'partner'           // for all requests from web
+//
+// tokens are cached until they expire, in the TokenCache, per tenant (Federation)
+type OAuth2Client struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Params       map[string]string // additional token request parameters, eg. audience
+	RefreshToken string            // if set, use the refresh token flow, instead of client credentials
+	AuthInParams bool              // send the client credentials in the request body, instead of basic auth
+}
+
+type OAuth2Clients map[string]*OAuth2Client
+
+// the requested oauth2 client is not configured
+type OAuth2ClientError struct {
+	Name string
+}
+
+func (e *OAuth2ClientError) Error() string {
+	return fmt.Sprintf("oauth2 client not found: '%s'", e.Name)
+}
+
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"` // zero = does not expire
+}
+
+// tokens are considered expired a little early, so they do not expire in flight
+const tokenExpiryDelta = 10 * time.Second
+
+// cached tokens. shared by runs, the host creates one and puts it in each Conf
+type TokenCache struct {
+	lock   sync.Mutex
+	tokens map[string]*OAuth2Token
+}
+
+func (cs OAuth2Clients) Get(name string) (*OAuth2Client, error) {
+	c, ok := cs[name]
+	if !ok || c == nil {
+		return nil, &OAuth2ClientError{name}
+	}
+	return c, nil
+}
+
+func (t *OAuth2Token) Valid(now time.Time) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || now.Add(tokenExpiryDelta).Before(t.Expiry)
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: make(map[string]*OAuth2Token)}
+}
+
+// the cached token, possibly expired (it may have a refresh token)
+func (c *TokenCache) Get(tenant, name string) *OAuth2Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.tokens[tokenKey(tenant, name)]
+}
+
+func (c *TokenCache) Put(tenant, name string, t *OAuth2Token) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tokens[tokenKey(tenant, name)] = t
+}
+
+// discard the access token, eg. after it was rejected. the refresh token is kept
+func (c *TokenCache) Invalidate(tenant, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	k := tokenKey(tenant, name)
+	t := c.tokens[k]
+	if t == nil {
+		return
+	}
+	if t.RefreshToken == "" {
+		delete(c.tokens, k)
+		return
+	}
+	c.tokens[k] = &OAuth2Token{RefreshToken: t.RefreshToken}
+}
+
+func tokenKey(tenant, name string) string {
+	return tenant + "\x00" + name
+}
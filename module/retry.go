@@ -0,0 +1,272 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 21:55 (EDT)
+// Function: retry failed http requests
+
+package module
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// http requests made through MASer.Transport/HTTPClient are retried according
+// to the policy attached to the request context, or the default (Conf) policy.
+// each retry counts as another network request. NetTimeout applies to each attempt,
+// not to all of them together. in js:
+//
+//	web.fetch(url, {retry: {attempts: 3, backoff: 200}})
+//	web.retry = {attempts: 3}              // for all requests from web
+//	var slack = module('ext/slack'); slack.retry = {attempts: 5, codes: [429, 503]}
+type RetryPolicy struct {
+	Attempts   int     `json:"attempts"`    // including the first. default 3
+	Backoff    int64   `json:"backoff"`     // msec, before the first retry, doubling after. default 200
+	MaxBackoff int64   `json:"max_backoff"` // msec. default 5000. a longer Retry-After gives up
+	Jitter     float64 `json:"jitter"`      // randomize each delay by +/- this fraction, eg. 0.2
+	Codes      []int   `json:"codes"`       // retry on these statuses. default 429, 502, 503, 504
+}
+
+const (
+	defaultAttempts   = 3
+	defaultBackoff    = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+var defaultRetryCodes = []int{429, 502, 503, 504}
+
+// a copy, which the script cannot change. nil if p is nil
+func (p *RetryPolicy) Clone() *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Codes = append([]int(nil), p.Codes...)
+	return &c
+}
+
+type retryKey struct{}
+
+// attach a retry policy to a request context
+func WithRetry(ctx context.Context, p *RetryPolicy) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryKey{}, p)
+}
+
+func retryFrom(ctx context.Context) *RetryPolicy {
+	p, _ := ctx.Value(retryKey{}).(*RetryPolicy)
+	return p
+}
+
+type timeoutKey struct{}
+
+// attach a timeout for each attempt of a request, if shorter than NetTimeout
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+	if d <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// wrap the transport to retry failed requests, using p if the request does not have a policy
+func (p *RetryPolicy) Transport(as MASer, rt http.RoundTripper) http.RoundTripper {
+	return &retryTransport{as, p, rt}
+}
+
+func (p *RetryPolicy) attempts() int {
+	if p.Attempts <= 0 {
+		return defaultAttempts
+	}
+	return p.Attempts
+}
+
+func (p *RetryPolicy) retryable(code int) bool {
+	codes := p.Codes
+	if len(codes) == 0 {
+		codes = defaultRetryCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// how long to wait before the next attempt. false = give up
+func (p *RetryPolicy) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+
+	d, max := defaultBackoff, defaultMaxBackoff
+	if p.Backoff > 0 {
+		d = time.Duration(p.Backoff) * time.Millisecond
+	}
+	if p.MaxBackoff > 0 {
+		max = time.Duration(p.MaxBackoff) * time.Millisecond
+	}
+
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	if d > max {
+		d = max
+	}
+
+	if retryAfter > max {
+		return 0, false
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d, true
+}
+
+// Retry-After: seconds, or a date
+func retryAfter(resp *http.Response) time.Duration {
+
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(h); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// ################################################################
+
+type retryTransport struct {
+	as  MASer
+	def *RetryPolicy
+	rt  http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	p := retryFrom(req.Context())
+	if p == nil {
+		p = t.def
+	}
+	if p == nil || (req.Body != nil && req.GetBody == nil) {
+		// nothing to do, or cannot resend the body
+		return t.attempt(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+
+		var wait time.Duration
+		switch {
+		case req.Context().Err() != nil:
+			return resp, err
+		case err != nil:
+		case p.retryable(resp.StatusCode):
+			wait = retryAfter(resp)
+		default:
+			return resp, err
+		}
+
+		if attempt >= p.attempts() {
+			return resp, err
+		}
+		d, ok := p.delay(attempt, wait)
+		if !ok {
+			return resp, err
+		}
+
+		// each attempt counts against the limit
+		closer, qerr := t.as.NetIOHeavy()
+		if qerr != nil {
+			return resp, err
+		}
+		closer()
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			t.as.Diagf("retrying %s %s in %v: %s", req.Method, req.URL, d, resp.Status)
+		} else {
+			t.as.Diagf("retrying %s %s in %v: %v", req.Method, req.URL, d, err)
+		}
+
+		req, err = rewind(req)
+		if err == nil {
+			err = sleepContext(req.Context(), d)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// one attempt, limited to NetTimeout (or the request's shorter timeout), including reading the body
+func (t *retryTransport) attempt(req *http.Request) (*http.Response, error) {
+
+	d := t.as.NetTimeout()
+	if rd, ok := req.Context().Value(timeoutKey{}).(time.Duration); ok && (d <= 0 || rd < d) {
+		d = rd
+	}
+	if d <= 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// releases the attempt's context when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// a copy of the request, with a fresh body
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 23:35 (EDT)
+// Function: named keys for signing requests
+
+package module
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+)
+
+// signing keys are configured by the host, and selected by name from js,
+// so secrets never appear in the script:
+//
+//	web.fetch(url, {sign: {type: 'jwt', key: 'partner', alg: 'RS256'}})
+//
+// pem data may be given inline, or read from a file
+type SigningKey struct {
+	Secret         string // hmac secret, or aws secret access key
+	AccessKey      string // aws access key id
+	SessionToken   string // aws, optional
+	PrivateKeyPEM  string // rsa or ecdsa, for jwt
+	PrivateKeyFile string //
+	KeyID          string // jwt 'kid' header, optional
+
+	once   sync.Once
+	signer crypto.Signer
+	err    error
+}
+
+type SigningKeys map[string]*SigningKey
+
+// the requested signing key is not configured
+type SigningKeyError struct {
+	Name string
+}
+
+func (e *SigningKeyError) Error() string {
+	return fmt.Sprintf("signing key not found: '%s'", e.Name)
+}
+
+func (ks SigningKeys) Get(name string) (*SigningKey, error) {
+	k, ok := ks[name]
+	if !ok || k == nil {
+		return nil, &SigningKeyError{name}
+	}
+	return k, nil
+}
+
+// the private key, parsed once
+func (k *SigningKey) Signer() (crypto.Signer, error) {
+	k.once.Do(func() {
+		k.signer, k.err = parsePrivateKey(k.PrivateKeyPEM, k.PrivateKeyFile)
+	})
+	return k.signer, k.err
+}
+
+// rsa, ecdsa, or ed25519
+func parsePrivateKey(inline, file string) (crypto.Signer, error) {
+
+	data, err := pemData(inline, file)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no private key")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if s, ok := key.(crypto.Signer); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unsupported private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("invalid private key")
+}
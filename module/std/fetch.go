@@ -0,0 +1,151 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 21:10 (EDT)
+// Function: web.fetch - a general web request
+
+package modstd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// in js code:
+//
+//	var r = web.fetch('https://api.example.com/v1/thing', {
+//	    method: 'PUT',
+//	    query: { id: 123 },
+//	    headers: { 'X-Thing': 'yes' },
+//	    json: { name: 'thing' },
+//	    auth: { bearer: token },
+//	    timeout: 500,
+//	})
+
+// options for web.fetch(url, opts)
+type FetchOpts struct {
+	Method          string                 `json:"method"`  // default GET
+	Headers         map[string]interface{} `json:"headers"` // string, or array of strings
+	Query           map[string]interface{} `json:"query"`   // string, or array of strings
+	Body            string                 `json:"body"`
+	JSON            interface{}            `json:"json"` // encoded as json
+	Auth            *FetchAuth             `json:"auth"`
+	Timeout         int64                  `json:"timeout"`          // msec, cannot exceed the configured limit
+	FollowRedirects *bool                  `json:"follow_redirects"` // default true
+	DecodeJSON      bool                   `json:"decode_json"`      // decode json responses into result.data
+	Retry           *module.RetryPolicy    `json:"retry"`
+	TLS             string                 `json:"tls"`    // tls profile name
+	Sign            *SignOpts              `json:"sign"`   // see std/sign
+	OAuth2          string                 `json:"oauth2"` // oauth2 client name
+}
+
+// either basic or bearer
+type FetchAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Bearer   string `json:"bearer"`
+}
+
+func (m *modWeb) Fetch(rawurl string, opts *FetchOpts) (*WebResult, error) {
+
+	r, err := buildFetch(rawurl, opts)
+	if err != nil {
+		return nil, fmt.Errorf("web.fetch: %v", err)
+	}
+	return m.do(r)
+}
+
+func buildFetch(rawurl string, opts *FetchOpts) (*webRequest, error) {
+
+	if opts == nil {
+		opts = &FetchOpts{}
+	}
+
+	r := &webRequest{
+		method:     strings.ToUpper(opts.Method),
+		hdrs:       make(http.Header),
+		body:       opts.Body,
+		timeout:    time.Duration(opts.Timeout) * time.Millisecond,
+		noRedirect: opts.FollowRedirects != nil && !*opts.FollowRedirects,
+		decodeJSON: opts.DecodeJSON,
+		retry:      opts.Retry,
+		tls:        opts.TLS,
+		sign:       opts.Sign,
+		oauth2:     opts.OAuth2,
+	}
+	if r.method == "" {
+		r.method = "GET"
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Query) != 0 {
+		q := u.Query()
+		for k, v := range opts.Query {
+			for _, s := range stringList(v) {
+				q.Add(k, s)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	r.url = u.String()
+
+	hdrs := http.Header(r.hdrs)
+	for k, v := range opts.Headers {
+		for _, s := range stringList(v) {
+			hdrs.Add(k, s)
+		}
+	}
+
+	if opts.JSON != nil {
+		if opts.Body != "" {
+			return nil, fmt.Errorf("cannot have both body and json")
+		}
+		js, err := json.Marshal(opts.JSON)
+		if err != nil {
+			return nil, err
+		}
+		r.body = string(js)
+		if hdrs.Get("Content-Type") == "" {
+			hdrs.Set("Content-Type", "application/json")
+		}
+	}
+
+	if a := opts.Auth; a != nil {
+		switch {
+		case a.Bearer != "":
+			hdrs.Set("Authorization", "Bearer "+a.Bearer)
+		case a.Username != "":
+			hdrs.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.Password)))
+		}
+	}
+
+	return r, nil
+}
+
+// a header or query value may be a single value, or an array
+func stringList(v interface{}) []string {
+
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var l []string
+		for _, x := range v {
+			l = append(l, fmt.Sprint(x))
+		}
+		return l
+	case []string:
+		return v
+	}
+
+	return []string{fmt.Sprint(v)}
+}
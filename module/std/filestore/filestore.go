@@ -0,0 +1,204 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 01:00 (EDT)
+// Function: key-value store in files
+
+package filestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// implements module.Store
+// each bucket is a directory, each key a file:
+//
+//	dir/bucket/key.json
+//
+// names are escaped, so any key is safe. writes are atomic (write + rename).
+// every Store on the same directory shares one lock, so updates are atomic
+// across all of them (eg. each run creating its own), but there is no locking
+// between processes sharing the directory
+type Store struct {
+	dir  string
+	lock *sync.Mutex
+}
+
+const (
+	suffix    = ".json"
+	tmpPrefix = ".tmp-"
+)
+
+// dir => lock
+var (
+	dirLocksLock sync.Mutex
+	dirLocks     = make(map[string]*sync.Mutex)
+)
+
+func New(dir string) *Store {
+	return &Store{dir: dir, lock: dirLock(dir)}
+}
+
+// the lock for the directory, shared by every Store using it
+func dirLock(dir string) *sync.Mutex {
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	dirLocksLock.Lock()
+	defer dirLocksLock.Unlock()
+
+	l := dirLocks[dir]
+	if l == nil {
+		l = &sync.Mutex{}
+		dirLocks[dir] = l
+	}
+	return l
+}
+
+func (s *Store) Get(bucket, key string) ([]byte, bool, error) {
+
+	buf, err := ioutil.ReadFile(s.file(bucket, key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return buf, true, nil
+}
+
+func (s *Store) Update(bucket, key string, f func([]byte, bool) ([]byte, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, found, err := s.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	value, err := f(old, found)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		err = os.Remove(s.file(bucket, key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return s.write(bucket, key, value)
+}
+
+func (s *Store) List(bucket, prefix string) ([]string, error) {
+
+	files, err := ioutil.ReadDir(s.bucketDir(bucket))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, suffix) || strings.HasPrefix(name, tmpPrefix) {
+			continue
+		}
+		key, err := unescape(strings.TrimSuffix(name, suffix))
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// write + rename, so readers never see a partial value
+func (s *Store) write(bucket, key string, value []byte) error {
+
+	dir := s.bucketDir(bucket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.file(bucket, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("store: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) bucketDir(bucket string) string {
+	if bucket == "" {
+		// escaped names never look like this
+		return filepath.Join(s.dir, "_")
+	}
+	return filepath.Join(s.dir, escape(bucket))
+}
+
+func (s *Store) file(bucket, key string) string {
+	return filepath.Join(s.bucketDir(bucket), escape(key)+suffix)
+}
+
+// letters, digits, '-', and non-leading '.' are kept, everything else is %XX
+func escape(s string) string {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			b.WriteByte(c)
+		case c == '.' && i != 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func unescape(s string) (string, error) {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("invalid name")
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 01:25 (EDT)
+// Function: file store tests
+
+package filestore
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jaw0/go-alertscript/module/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, New(t.TempDir()))
+}
+
+// separate Stores on the same directory do not lose updates
+func TestSharedDir(t *testing.T) {
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		s := New(dir)
+		if i%2 == 1 {
+			s = New(dir + "/.")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.Update("b", "n", func(v []byte, found bool) ([]byte, error) {
+					n, _ := strconv.Atoi(string(v))
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _, _ := New(dir).Get("b", "n"); string(v) != "500" {
+		t.Errorf("lost updates: %s, expected 500", v)
+	}
+}
+
+func TestNames(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir)
+
+	keys := []string{"a", "A.b", "..", "../../x", "", "a/b", "über", "%41"}
+	for i, k := range keys {
+		err := s.Update("", k, func([]byte, bool) ([]byte, error) { return []byte{byte(i)}, nil })
+		if err != nil {
+			t.Fatalf("put %q: %v", k, err)
+		}
+	}
+	for i, k := range keys {
+		v, found, err := s.Get("", k)
+		if err != nil || !found || len(v) != 1 || v[0] != byte(i) {
+			t.Errorf("get %q: %v %v %v", k, v, found, err)
+		}
+	}
+
+	// everything is inside the bucket
+	files, _ := filepath.Glob(filepath.Join(dir, "_", "*"))
+	if len(files) != len(keys) {
+		t.Errorf("files: %v", files)
+	}
+
+	listed, err := s.List("", "")
+	if err != nil || len(listed) != len(keys) {
+		t.Errorf("list: %q %v", listed, err)
+	}
+}
+
+func TestEscape(t *testing.T) {
+
+	tests := map[string]string{
+		"abc-1.2": "abc-1.2",
+		".x":      "%2Ex",
+		"..":      "%2E.",
+		"a/b":     "a%2Fb",
+		"a_b c":   "a%5Fb%20c",
+	}
+
+	for in, out := range tests {
+		if r := escape(in); r != out {
+			t.Errorf("%q => %q, expected %q", in, r, out)
+		}
+	}
+}
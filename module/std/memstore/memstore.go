@@ -3,58 +3,66 @@
 // Created: 2022-Apr-05 18:28 (EDT)
 // Function: simple in memory key-value store (for testing)
 
-package modstd
+package memstore
 
 import (
-	"encoding/json"
-
-	"github.com/jaw0/go-alertscript/module"
-	"github.com/dop251/goja"
+	"sort"
+	"strings"
+	"sync"
 )
 
-var _ = module.Register("std/store", installStore)
-
-type modStore struct {
-	as  module.MASer
-	bkt string
-	kvs map[string][]byte
+// implements module.Store
+type Store struct {
+	lock    sync.Mutex
+	buckets map[string]map[string][]byte
 }
 
-type Result struct {
-	Value interface{}
-	Found bool
+func New() *Store {
+	return &Store{buckets: make(map[string]map[string][]byte)}
 }
 
-func installStore(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &modStore{
-		as:  aser,
-		bkt: aser.Federation(),
-		kvs: make(map[string][]byte),
-	}
-	return m
+func (s *Store) Get(bucket, key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	buf, found := s.buckets[bucket][key]
+	return buf, found, nil
 }
 
-func (m *modStore) Put(key string, value interface{}) error {
+func (s *Store) Update(bucket, key string, f func([]byte, bool) ([]byte, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b := s.buckets[bucket]
+	old, found := b[key]
 
-	buf, err := json.Marshal(value)
+	value, err := f(old, found)
 	if err != nil {
 		return err
 	}
 
-	m.kvs[key] = buf
+	if value == nil {
+		delete(b, key)
+		return nil
+	}
+	if b == nil {
+		b = make(map[string][]byte)
+		s.buckets[bucket] = b
+	}
+	b[key] = append([]byte(nil), value...)
 	return nil
 }
 
-func (m *modStore) Get(key string) (*Result, error) {
-
-	buf, found := m.kvs[key]
+func (s *Store) List(bucket, prefix string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	if !found {
-		return &Result{}, nil
+	var keys []string
+	for k := range s.buckets[bucket] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
 	}
-
-	var value interface{}
-	json.Unmarshal(buf, &value)
-
-	return &Result{value, found}, nil
+	sort.Strings(keys)
+	return keys, nil
 }
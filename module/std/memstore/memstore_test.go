@@ -0,0 +1,16 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 02:05 (EDT)
+// Function: memory store tests
+
+package memstore
+
+import (
+	"testing"
+
+	"github.com/jaw0/go-alertscript/module/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, New())
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 23:20 (EDT)
+// Function: web.post_multipart - multipart/form-data uploads
+
+package modstd
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// in js code:
+//
+//	var b64 = module('std/encoding/base64')
+//	var r = web.post_multipart(url, {}, {
+//	    fields: { summary: 'disk full', tags: ['ops', 'disk'] },
+//	    files: [{ name: 'screenshot', filename: 'df.png', type: 'image/png', content: b64.std.decode(png) }],
+//	})
+
+type MultipartForm struct {
+	Fields map[string]interface{} `json:"fields"` // string, or array of strings
+	Files  []*MultipartFile       `json:"files"`
+}
+
+type MultipartFile struct {
+	Name     string      `json:"name"` // form field name
+	Filename string      `json:"filename"`
+	Type     string      `json:"type"`    // default application/octet-stream
+	Content  interface{} `json:"content"` // string, or binary ([]byte, ArrayBuffer)
+}
+
+func (m *modWeb) postMultipart(url string, hdrs map[string][]string, form *MultipartForm) (*WebResult, error) {
+
+	body, ctype, err := buildMultipart(form)
+	if err != nil {
+		return nil, fmt.Errorf("web.post_multipart: %v", err)
+	}
+
+	h := make(map[string][]string, len(hdrs)+1)
+	for k, v := range hdrs {
+		h[k] = v
+	}
+	h["Content-Type"] = []string{ctype}
+
+	return m.do(&webRequest{url: url, method: "POST", hdrs: h, body: body})
+}
+
+// the body, and its content-type
+func buildMultipart(form *MultipartForm) (string, string, error) {
+
+	if form == nil {
+		form = &MultipartForm{}
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	// in a predictable order
+	keys := make([]string, 0, len(form.Fields))
+	for k := range form.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range stringList(form.Fields[k]) {
+			if err := w.WriteField(k, v); err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	for _, f := range form.Files {
+		if f == nil || f.Name == "" {
+			return "", "", fmt.Errorf("file name is required")
+		}
+		content, err := binaryContent(f.Content)
+		if err != nil {
+			return "", "", fmt.Errorf("file '%s': %v", f.Name, err)
+		}
+
+		ctype := f.Type
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(f.Name), quoteEscaper.Replace(f.Filename)))
+		h.Set("Content-Type", ctype)
+
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", "", err
+		}
+		pw.Write(content)
+	}
+
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+
+	return buf.String(), w.FormDataContentType(), nil
+}
+
+// same as mime/multipart
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func binaryContent(v interface{}) ([]byte, error) {
+
+	switch c := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(c), nil
+	case []byte:
+		return c, nil
+	case goja.ArrayBuffer:
+		return c.Bytes(), nil
+	case []interface{}:
+		// an array of octets
+		b := make([]byte, len(c))
+		for i, x := range c {
+			n, ok := x.(int64)
+			if !ok || n < 0 || n > 255 {
+				return nil, fmt.Errorf("invalid content")
+			}
+			b[i] = byte(n)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("invalid content type %T", v)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 00:25 (EDT)
+// Function: oauth2 access tokens
+
+package modstd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/dop251/goja"
+)
+
+// clients are configured by the host (Conf.OAuth2), and referred to by name.
+// a token request is only made if there is no valid cached token.
+// in js code:
+//
+//	web.fetch(url, {oauth2: 'partner'})
+//	web.oauth2 = 'partner'
+//
+//	var oauth2 = module('std/oauth2')
+//	var tok = oauth2.token('partner')
+//	oauth2.invalidate('partner')      // the token was rejected
+
+var _ = module.Register("std/oauth2", installOAuth2)
+
+// exported to js:
+type modOAuth2 struct {
+	as         module.MASer
+	TokenAsync func(goja.FunctionCall) goja.Value `json:"token_async"`
+}
+
+type OAuth2Result struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Expiry      int64  `json:"expiry"` // js units. 0 = does not expire
+}
+
+func installOAuth2(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
+	m := &modOAuth2{as: aser}
+	m.TokenAsync = module.Async(aser, vm, m.Token)
+	return m
+}
+
+// a valid access token, from the cache, or the token endpoint
+func (m *modOAuth2) Token(name string) (*OAuth2Result, error) {
+
+	tok, err := oauth2Token(m.as, name)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &OAuth2Result{AccessToken: tok.AccessToken, TokenType: tok.TokenType}
+	if !tok.Expiry.IsZero() {
+		res.Expiry = tok.Expiry.UnixNano() / 1e6
+	}
+	return res, nil
+}
+
+// discard the cached access token
+func (m *modOAuth2) Invalidate(name string) {
+	m.as.TokenCache().Invalidate(m.as.Federation(), name)
+}
+
+// ################################################################
+
+func oauth2Token(as module.MASer, name string) (*module.OAuth2Token, error) {
+
+	cl, err := as.OAuth2Client(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := as.TokenCache()
+	tok := cache.Get(as.Federation(), name)
+	if tok.Valid(time.Now()) {
+		return tok, nil
+	}
+
+	refresh := cl.RefreshToken
+	if tok != nil && tok.RefreshToken != "" {
+		// it may have been rotated
+		refresh = tok.RefreshToken
+	}
+
+	tok, err = fetchToken(as, cl, refresh)
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refresh
+	}
+
+	if !as.IsDryRun() {
+		cache.Put(as.Federation(), name, tok)
+	}
+	return tok, nil
+}
+
+func fetchToken(as module.MASer, cl *module.OAuth2Client, refresh string) (*module.OAuth2Token, error) {
+
+	call := as.Call("std/oauth2", "token", cl.TokenURL)
+	defer call.Done()
+
+	closer, err := as.NetIOHeavy()
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		call.Result(0, err)
+		as.Fatal(err)
+		return nil, err
+	}
+
+	as.Diagf("oauth2: token from %s", cl.TokenURL)
+	if as.IsDryRun() {
+		if mk := as.Mock(call); mk != nil {
+			if err := mk.Failure(); err != nil {
+				call.Result(mk.StatusCode(), err)
+				return nil, err
+			}
+			if mk.Body != "" {
+				return parseToken(mk.StatusCode(), []byte(mk.Body), time.Now())
+			}
+		}
+		return &module.OAuth2Token{AccessToken: "not-tried", TokenType: "Bearer"}, nil
+	}
+
+	form := url.Values{}
+	if refresh != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refresh)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(cl.Scopes) != 0 {
+		form.Set("scope", strings.Join(cl.Scopes, " "))
+	}
+	for k, v := range cl.Params {
+		form.Set(k, v)
+	}
+	if cl.AuthInParams {
+		form.Set("client_id", cl.ClientID)
+		form.Set("client_secret", cl.ClientSecret)
+	}
+
+	// the client secret + tokens are not recorded
+	ctx := module.WithRedactedBodies(as.Context())
+	req, err := http.NewRequestWithContext(ctx, "POST", cl.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		call.Result(0, err)
+		as.Error(err)
+		return nil, fmt.Errorf("oauth2: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !cl.AuthInParams {
+		// rfc 6749 2.3.1
+		req.SetBasicAuth(url.QueryEscape(cl.ClientID), url.QueryEscape(cl.ClientSecret))
+	}
+
+	tok, code, err := requestToken(as, req)
+	call.Result(code, err)
+	if err != nil {
+		as.NetIOErr()
+		as.Logf("oauth2 token request failed: %v", err)
+		return nil, err
+	}
+	return tok, nil
+}
+
+func requestToken(as module.MASer, req *http.Request) (*module.OAuth2Token, int, error) {
+
+	err := as.NetPolicy().CheckURL(req.URL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := as.HTTPClient().Do(req)
+	if err != nil {
+		return nil, 500, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, as.MaxResponseSize()))
+	if err != nil {
+		return nil, 500, err
+	}
+
+	tok, err := parseToken(resp.StatusCode, body, time.Now())
+	return tok, resp.StatusCode, err
+}
+
+// rfc 6749 5.1, 5.2
+func parseToken(code int, body []byte, now time.Time) (*module.OAuth2Token, error) {
+
+	var r struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		RefreshToken     string      `json:"refresh_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	jerr := json.Unmarshal(body, &r)
+
+	switch {
+	case r.Error != "":
+		return nil, fmt.Errorf("oauth2: %d %s %s", code, r.Error, r.ErrorDescription)
+	case code != 200:
+		return nil, fmt.Errorf("oauth2: %d %s", code, http.StatusText(code))
+	case jerr != nil:
+		return nil, fmt.Errorf("oauth2: invalid response: %v", jerr)
+	case r.AccessToken == "":
+		return nil, fmt.Errorf("oauth2: no access token in response")
+	}
+
+	tok := &module.OAuth2Token{
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		RefreshToken: r.RefreshToken,
+	}
+	if tok.TokenType == "" || strings.EqualFold(tok.TokenType, "bearer") {
+		tok.TokenType = "Bearer"
+	}
+	if n, err := r.ExpiresIn.Int64(); err == nil && n > 0 {
+		tok.Expiry = now.Add(time.Duration(n) * time.Second)
+	}
+	return tok, nil
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 23:45 (EDT)
+// Function: sign outgoing requests - aws sigv4, hmac webhooks, jwt
+
+package modstd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/dop251/goja"
+)
+
+// keys are configured by the host (Conf.SigningKeys), and referred to by name.
+// in js code:
+//
+//	web.fetch(url, {method: 'POST', body: data, sign: {type: 'github', key: 'hook'}})
+//	web.fetch(url, {sign: {type: 'aws', key: 'prod', region: 'us-east-1', service: 'execute-api'}})
+//	web.fetch(url, {sign: {type: 'jwt', key: 'svc', alg: 'ES256', claims: {iss: 'alerts', aud: 'api'}}})
+//
+//	var sign = module('std/sign')
+//	var hdrs = sign.headers('POST', url, {'Content-Type': ['application/json']}, body, {type: 'slack', key: 'slack'})
+//	var token = sign.jwt({key: 'svc', claims: {sub: 'me'}})
+
+var _ = module.Register("std/sign", installSign)
+
+// exported to js:
+type modSign struct {
+	as module.MASer
+}
+
+type SignOpts struct {
+	Type string `json:"type"` // aws, hmac, github, stripe, slack, jwt
+	Key  string `json:"key"`  // name of the host provided key
+	// aws
+	Region  string `json:"region"`
+	Service string `json:"service"`
+	// hmac
+	Header          string `json:"header"`           // default X-Signature
+	TimestampHeader string `json:"timestamp_header"` // if set, sign "timestamp.body", and send the timestamp
+	Prefix          string `json:"prefix"`           // eg. "sha256="
+	Hash            string `json:"hash"`             // sha1, sha256, sha512. default sha256
+	Encoding        string `json:"encoding"`         // hex, base64. default hex
+	// jwt
+	Alg    string                 `json:"alg"` // HS256, RS256, ES256. default HS256
+	Claims map[string]interface{} `json:"claims"`
+	TTL    int64                  `json:"ttl"` // seconds, default 300
+}
+
+const defaultJWTTTL = 300
+
+var signHashes = map[string]func() hash.Hash{
+	"":       sha256.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func installSign(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
+	return &modSign{as: aser}
+}
+
+// the request headers, with the signature added
+func (m *modSign) Headers(method, rawurl string, hdrs map[string][]string, body string, opts *SignOpts) (map[string][]string, error) {
+
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sign.headers: %v", err)
+	}
+	for k, v := range hdrs {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	err = signRequest(m.as, req, []byte(body), opts, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("sign.headers: %v", err)
+	}
+	return req.Header, nil
+}
+
+// a signed jwt
+func (m *modSign) Jwt(opts *SignOpts) (string, error) {
+
+	if opts == nil {
+		return "", fmt.Errorf("sign.jwt(opts)")
+	}
+	key, err := m.as.SigningKey(opts.Key)
+	if err != nil {
+		return "", err
+	}
+	tok, err := makeJWT(key, opts, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("sign.jwt: %v", err)
+	}
+	return tok, nil
+}
+
+// add the signature to the request
+func signRequest(as module.MASer, req *http.Request, body []byte, opts *SignOpts, now time.Time) error {
+
+	if opts == nil {
+		return nil
+	}
+	key, err := as.SigningKey(opts.Key)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(opts.Type) {
+	case "aws":
+		return signAWS(req, body, key, opts, now)
+	case "hmac":
+		return signHMAC(req, body, key, opts, now)
+	case "github":
+		o := &SignOpts{Header: "X-Hub-Signature-256", Prefix: "sha256="}
+		return signHMAC(req, body, key, o, now)
+	case "stripe":
+		ts := strconv.FormatInt(now.Unix(), 10)
+		sig, err := hmacSign(key, "", "", ts+"."+string(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Stripe-Signature", "t="+ts+",v1="+sig)
+		return nil
+	case "slack":
+		ts := strconv.FormatInt(now.Unix(), 10)
+		sig, err := hmacSign(key, "", "", "v0:"+ts+":"+string(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Slack-Request-Timestamp", ts)
+		req.Header.Set("X-Slack-Signature", "v0="+sig)
+		return nil
+	case "jwt":
+		tok, err := makeJWT(key, opts, now)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+		return nil
+	}
+
+	return fmt.Errorf("unknown signature type '%s'", opts.Type)
+}
+
+// the headers in b that are not the same in a
+func changedHeaders(a, b http.Header) []string {
+
+	var names []string
+	for k, v := range b {
+		if strings.Join(a[k], "\n") != strings.Join(v, "\n") {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+// ################################################################
+
+func signHMAC(req *http.Request, body []byte, key *module.SigningKey, opts *SignOpts, now time.Time) error {
+
+	payload := string(body)
+	if opts.TimestampHeader != "" {
+		ts := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set(opts.TimestampHeader, ts)
+		payload = ts + "." + payload
+	}
+
+	sig, err := hmacSign(key, opts.Hash, opts.Encoding, payload)
+	if err != nil {
+		return err
+	}
+
+	hdr := opts.Header
+	if hdr == "" {
+		hdr = "X-Signature"
+	}
+	req.Header.Set(hdr, opts.Prefix+sig)
+	return nil
+}
+
+func hmacSign(key *module.SigningKey, hashName, enc, payload string) (string, error) {
+
+	if key.Secret == "" {
+		return "", fmt.Errorf("hmac signing requires a secret")
+	}
+	hf, ok := signHashes[strings.ToLower(hashName)]
+	if !ok {
+		return "", fmt.Errorf("unknown hash '%s'", hashName)
+	}
+
+	h := hmac.New(hf, []byte(key.Secret))
+	h.Write([]byte(payload))
+	sum := h.Sum(nil)
+
+	switch strings.ToLower(enc) {
+	case "", "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("unknown encoding '%s'", enc)
+}
+
+// ################################################################
+
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signAWS(req *http.Request, body []byte, key *module.SigningKey, opts *SignOpts, now time.Time) error {
+
+	if key.AccessKey == "" || key.Secret == "" {
+		return fmt.Errorf("aws signing requires an access key and secret")
+	}
+	if opts.Region == "" || opts.Service == "" {
+		return fmt.Errorf("aws signing requires a region and service")
+	}
+
+	t := now.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if key.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", key.SessionToken)
+	}
+	if opts.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// sign the host, content-type, and x-amz-* headers
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk != "content-type" && !strings.HasPrefix(lk, "x-amz-") {
+			continue
+		}
+		var vs []string
+		for _, s := range v {
+			vs = append(vs, strings.Join(strings.Fields(s), " "))
+		}
+		values[lk] = strings.Join(vs, ",")
+	}
+	var names []string
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHdrs strings.Builder
+	for _, k := range names {
+		canonHdrs.WriteString(k + ":" + values[k] + "\n")
+	}
+	signedHdrs := strings.Join(names, ";")
+
+	// s3 paths are only escaped once
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if opts.Service != "s3" {
+		path = awsEscape(path, false)
+	}
+
+	creq := strings.Join([]string{
+		req.Method,
+		path,
+		awsQuery(req),
+		canonHdrs.String(),
+		signedHdrs,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + opts.Region + "/" + opts.Service + "/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(creq))
+
+	k := hmacSHA256([]byte("AWS4"+key.Secret), date)
+	k = hmacSHA256(k, opts.Region)
+	k = hmacSHA256(k, opts.Service)
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, sts))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		key.AccessKey, scope, signedHdrs, sig))
+	return nil
+}
+
+func awsQuery(req *http.Request) string {
+
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// aws uri encoding: everything except the unreserved characters
+func awsEscape(s string, slash bool) string {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// ################################################################
+
+func makeJWT(key *module.SigningKey, opts *SignOpts, now time.Time) (string, error) {
+
+	alg := strings.ToUpper(opts.Alg)
+	if alg == "" {
+		alg = "HS256"
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = defaultJWTTTL
+	}
+
+	hdr := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if key.KeyID != "" {
+		hdr["kid"] = key.KeyID
+	}
+	claims := map[string]interface{}{
+		"iat": now.Unix(),
+		"exp": now.Unix() + ttl,
+	}
+	for k, v := range opts.Claims {
+		claims[k] = v
+	}
+
+	hj, err := json.Marshal(hdr)
+	if err != nil {
+		return "", err
+	}
+	cj, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString(hj) + "." + enc.EncodeToString(cj)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+
+	switch alg {
+	case "HS256":
+		if key.Secret == "" {
+			return "", fmt.Errorf("HS256 requires a secret")
+		}
+		sig = hmacSHA256([]byte(key.Secret), input)
+
+	case "RS256":
+		s, err := key.Signer()
+		if err != nil {
+			return "", err
+		}
+		rk, ok := s.(*rsa.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("RS256 requires an rsa key")
+		}
+		sig, err = rsa.SignPKCS1v15(rand.Reader, rk, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+
+	case "ES256":
+		s, err := key.Signer()
+		if err != nil {
+			return "", err
+		}
+		ek, ok := s.(*ecdsa.PrivateKey)
+		if !ok || ek.Curve != elliptic.P256() {
+			return "", fmt.Errorf("ES256 requires a P-256 ecdsa key")
+		}
+		r, ss, err := ecdsa.Sign(rand.Reader, ek, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// fixed size r || s, not asn.1
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+
+	default:
+		return "", fmt.Errorf("unsupported jwt alg '%s'", opts.Alg)
+	}
+
+	return input + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 23:58 (EDT)
+// Function: request signing tests
+
+package modstd
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// from the aws sigv4 test suite (get-vanilla)
+func TestSignAWS(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	key := &module.SigningKey{AccessKey: "AKIDEXAMPLE", Secret: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	err := signAWS(req, nil, key, &SignOpts{Region: "us-east-1", Service: "service"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if a := req.Header.Get("Authorization"); a != exp {
+		t.Fatalf("got %s", a)
+	}
+	if d := req.Header.Get("X-Amz-Date"); d != "20150830T123600Z" {
+		t.Fatalf("date %s", d)
+	}
+}
+
+func TestAWSEscape(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		slash bool
+		out   string
+	}{
+		{"/a b/c~d", false, "/a%20b/c~d"},
+		{"/a%20b", false, "/a%2520b"},
+		{"a/b=c+d", true, "a%2Fb%3Dc%2Bd"},
+	}
+
+	for _, test := range tests {
+		if r := awsEscape(test.in, test.slash); r != test.out {
+			t.Errorf("%s => %s, expected %s", test.in, r, test.out)
+		}
+	}
+}
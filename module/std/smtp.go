@@ -7,9 +7,15 @@ package modstd
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/mail"
 	"net/smtp"
+	"strings"
+	"time"
 
 	"github.com/jaw0/go-alertscript/module"
 	"github.com/domodwyer/mailyak"
@@ -20,26 +26,51 @@ var _ = module.Register("std/smtp", installSMTP)
 
 // exported to js:
 type modSMTP struct {
-	as module.MASer
+	as        module.MASer
+	SendAsync func(goja.FunctionCall) goja.Value `json:"send_async"`
 	// QQQ - provide helpers?
 }
 
+// tls:
+//
+//	""                      use STARTTLS if the server offers it (the default)
+//	none                    do not use tls
+//	starttls                require STARTTLS
+//	implicit                connect with tls (smtps, port 465)
+//	insecure_skip_verify    use STARTTLS if offered, but do not verify the certificate
 type SmtpServer struct {
 	Hostname string `json:"hostname"`
-	Port     int    `json:"port"`
+	Port     int    `json:"port"` // default 25, or 465 for implicit tls
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Auth     string `json:"auth"` // plain (default), login, cram-md5
+	TLS      string `json:"tls"`
 }
 
+const (
+	smtpTLSDefault    = ""
+	smtpTLSNone       = "none"
+	smtpTLSStartTLS   = "starttls"
+	smtpTLSImplicit   = "implicit"
+	smtpTLSSkipVerify = "insecure_skip_verify"
+)
+
 type SmtpAttach struct {
 	Name    string `json:"name"`
 	Type    string `json:"type"` // MIME-type
 	Content string `json:"content"`
 }
 
+// to, cc, and bcc may each be:
+//
+//	'bob@example.com'
+//	'Bob <bob@example.com>, alice@example.com'
+//	['bob@example.com', {address: 'alice@example.com', name: 'Alice'}]
 type SmtpMsg struct {
-	To       string              `json:"to"`
-	ToName   string              `json:"to_name"`
+	To       interface{}         `json:"to"`
+	ToName   string              `json:"to_name"` // if to is a single address without a name
+	Cc       interface{}         `json:"cc"`
+	Bcc      interface{}         `json:"bcc"`
 	From     string              `json:"from"`
 	FromName string              `json:"from_name"`
 	ReplyTo  string              `json:"reply_to"`
@@ -56,12 +87,13 @@ type SmtpResult struct {
 }
 
 func installSMTP(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	m := &modSMTP{aser}
+	m := &modSMTP{as: aser}
+	m.SendAsync = module.Async(aser, vm, m.Send)
 	return m
 }
 
 func NewSmtp(aser module.MASer) *modSMTP {
-	return &modSMTP{aser}
+	return &modSMTP{as: aser}
 }
 
 func (m *modSMTP) Send(srv *SmtpServer, msg *SmtpMsg) (*SmtpResult, error) {
@@ -69,35 +101,66 @@ func (m *modSMTP) Send(srv *SmtpServer, msg *SmtpMsg) (*SmtpResult, error) {
 	if srv == nil || msg == nil {
 		return nil, fmt.Errorf("smtp.send(server, message)")
 	}
+
+	auth, err := srv.auth()
+	if err != nil {
+		return nil, err
+	}
+	to, cc, bcc, err := msg.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	rcpts := envelope(to, cc, bcc)
+	if len(rcpts) == 0 {
+		return nil, fmt.Errorf("smtp: no recipients")
+	}
+
+	if srv.Port == 0 {
+		srv.Port = 25
+		if srv.TLS == smtpTLSImplicit {
+			srv.Port = 465
+		}
+	}
+	addr := net.JoinHostPort(srv.Hostname, fmt.Sprintf("%d", srv.Port))
+
+	call := m.as.Call("std/smtp", "send", addr)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
 
 	// for debugging
-	m.as.Diagf("sending mail to: %s via: %s", msg.To, srv.Hostname)
+	m.as.Diagf("sending mail to: %s via: %s", strings.Join(rcpts, ", "), srv.Hostname)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			if err := mk.Failure(); err != nil {
+				call.Result(mk.StatusCode(), err)
+				m.as.NetIOErr()
+				m.as.Logf("smtp error %v", err)
+				return &SmtpResult{500, err.Error()}, nil
+			}
+			return &SmtpResult{mk.StatusCode(), mk.Status()}, nil
+		}
 		return &SmtpResult{200, "not tried"}, nil
 	}
 
-	if srv.Port == 0 {
-		srv.Port = 25
-	}
-
-	var mail *mailyak.MailYak
+	// we do the sending
+	mail := mailyak.New(addr, nil)
 
-	if srv.Username != "" {
-		mail = mailyak.New(net.JoinHostPort(srv.Hostname, fmt.Sprintf("%d", srv.Port)),
-			smtp.PlainAuth("", srv.Username, srv.Password, srv.Hostname))
-	} else {
-		mail = mailyak.New(net.JoinHostPort(srv.Hostname, fmt.Sprintf("%d", srv.Port)), nil)
+	// one header each, with names. bcc is only in the envelope
+	if len(to) != 0 {
+		mail.AddHeader("To", joinAddrs(to))
+	}
+	if len(cc) != 0 {
+		mail.AddHeader("Cc", joinAddrs(cc))
 	}
-
-	mail.To(msg.To)
 	mail.From(msg.From)
 	mail.FromName(msg.FromName)
 	mail.Subject(msg.Subject)
@@ -128,8 +191,22 @@ func (m *modSMTP) Send(srv *SmtpServer, msg *SmtpMsg) (*SmtpResult, error) {
 		mail.AttachWithMimeType(a.Name, b, a.Type)
 	}
 
-	err = mail.Send()
+	buf, err := mail.MimeBuf()
+	if err != nil {
+		call.Result(0, err)
+		return nil, fmt.Errorf("smtp: cannot build message: %v", err)
+	}
+
+	body, err := m.dkimSign(msg.From, buf.Bytes())
+	if err != nil {
+		call.Result(0, err)
+		m.as.Error(err)
+		return nil, err
+	}
+
+	err = m.deliver(addr, srv, auth, envelopeFrom(msg.From), rcpts, body)
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("smtp error %v", err)
 		return &SmtpResult{500, err.Error()}, nil
@@ -137,3 +214,286 @@ func (m *modSMTP) Send(srv *SmtpServer, msg *SmtpMsg) (*SmtpResult, error) {
 
 	return &SmtpResult{200, "OK"}, nil
 }
+
+// the envelope sender. msg.From may be "Name <addr>", let the server reject anything unparseable
+func envelopeFrom(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return from
+}
+
+// sign the message, if the host has a dkim key for the sender's domain
+func (m *modSMTP) dkimSign(from string, msg []byte) ([]byte, error) {
+
+	// may be "Name <addr>", and the local part may be quoted
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		m.as.Diagf("dkim: cannot parse from address '%s': %v", from, err)
+		return msg, nil
+	}
+	domain := addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	key := m.as.DKIMKey(domain)
+	if key == nil {
+		return msg, nil
+	}
+
+	m.as.Diagf("dkim signing for %s, selector %s", domain, key.Selector)
+	return key.Sign(domain, msg, time.Now())
+}
+
+// send the message, or replay a previous send
+func (m *modSMTP) deliver(addr string, srv *SmtpServer, auth smtp.Auth, from string, to []string, msg []byte) error {
+
+	cas := m.as.Cassette()
+	dest := "smtp://" + addr
+
+	if cas.Replaying() {
+		it, err := cas.Replay(module.KindSMTP, "SEND", dest)
+		if err != nil {
+			return err
+		}
+		if it.Error != "" {
+			return errors.New(it.Error)
+		}
+		return nil
+	}
+
+	tlsc := &tls.Config{ServerName: srv.Hostname, InsecureSkipVerify: srv.TLS == smtpTLSSkipVerify}
+	err := sendMail(m.as.Context(), m.as.NetPolicy(), m.as.NetTimeout(), addr, srv.Hostname, srv.TLS, tlsc, auth, from, to, msg)
+
+	if cas.Recording() {
+		it := &module.Interaction{Kind: module.KindSMTP, Method: "SEND", URL: dest, Code: 200, ReqBody: string(msg)}
+		if err != nil {
+			it.Code = 500
+			it.Error = err.Error()
+		}
+		cas.Record(it)
+	}
+
+	return err
+}
+
+// like smtp.SendMail, but can be cancelled, and gives up on a server that stalls
+func sendMail(ctx context.Context, pol *module.NetPolicy, timeout time.Duration, addr, host, mode string, tlsc *tls.Config, auth smtp.Auth, from string, to []string, msg []byte) error {
+
+	conn, err := pol.DialContext(ctx, &net.Dialer{Timeout: timeout}, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	// for the whole conversation
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if mode == smtpTLSImplicit {
+		tc := tls.Client(conn, tlsc)
+		if err = tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tc
+	}
+
+	// abort the conversation if the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	switch mode {
+	case smtpTLSDefault, smtpTLSStartTLS, smtpTLSSkipVerify:
+		ok, _ := c.Extension("STARTTLS")
+		if !ok && mode == smtpTLSStartTLS {
+			return fmt.Errorf("smtp: server doesn't support STARTTLS")
+		}
+		if ok {
+			if err = c.StartTLS(tlsc); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// ################################################################
+
+func (srv *SmtpServer) auth() (smtp.Auth, error) {
+
+	switch srv.TLS {
+	case smtpTLSDefault, smtpTLSNone, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSSkipVerify:
+	default:
+		return nil, fmt.Errorf("smtp: invalid tls '%s'", srv.TLS)
+	}
+
+	if srv.Username == "" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(srv.Auth) {
+	case "", "plain":
+		return smtp.PlainAuth("", srv.Username, srv.Password, srv.Hostname), nil
+	case "login":
+		return &loginAuth{srv.Username, srv.Password, srv.Hostname}, nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(srv.Username, srv.Password), nil
+	}
+	return nil, fmt.Errorf("smtp: invalid auth '%s'", srv.Auth)
+}
+
+// the obsolete, but still common, LOGIN mechanism
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+
+	// like smtp.PlainAuth, do not send the password in the clear
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("smtp: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("smtp: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.TrimSuffix(strings.ToLower(strings.TrimSpace(string(fromServer))), ":") {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("smtp: unexpected LOGIN challenge '%s'", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// the to, cc, and bcc addresses
+func (msg *SmtpMsg) Recipients() (to, cc, bcc []*mail.Address, err error) {
+
+	to, err = parseAddrs("to", msg.To)
+	if err != nil {
+		return
+	}
+	if len(to) == 1 && to[0].Name == "" {
+		to[0].Name = msg.ToName
+	}
+	cc, err = parseAddrs("cc", msg.Cc)
+	if err != nil {
+		return
+	}
+	bcc, err = parseAddrs("bcc", msg.Bcc)
+	return
+}
+
+func parseAddrs(field string, v interface{}) ([]*mail.Address, error) {
+
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		a, err := mail.ParseAddressList(v)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: invalid %s '%s': %v", field, v, err)
+		}
+		return a, nil
+	case []interface{}:
+		var res []*mail.Address
+		for _, e := range v {
+			var a []*mail.Address
+			var err error
+
+			switch e := e.(type) {
+			case map[string]interface{}:
+				addr, _ := e["address"].(string)
+				name, _ := e["name"].(string)
+				if addr == "" {
+					return nil, fmt.Errorf("smtp: invalid %s, missing address", field)
+				}
+				a, err = parseAddrs(field, addr)
+				if len(a) == 1 {
+					a[0].Name = name
+				}
+			default:
+				a, err = parseAddrs(field, e)
+			}
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, a...)
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("smtp: invalid %s", field)
+}
+
+func joinAddrs(addrs []*mail.Address) string {
+
+	var s []string
+	for _, a := range addrs {
+		s = append(s, a.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func envelope(lists ...[]*mail.Address) []string {
+
+	var rcpts []string
+	for _, l := range lists {
+		for _, a := range l {
+			rcpts = append(rcpts, a.Address)
+		}
+	}
+	return rcpts
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 02:50 (EDT)
+// Function: smtp tests
+
+package modstd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// a minimal smtp server
+type fakeSMTP struct {
+	ln       net.Listener
+	cert     tls.Certificate
+	implicit bool
+	starttls bool
+
+	lock  sync.Mutex
+	tls   bool
+	user  string
+	from  string
+	rcpts []string
+	data  string
+}
+
+func newFakeSMTP(t *testing.T, cert tls.Certificate, implicit, starttls bool) *fakeSMTP {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, cert: cert, implicit: implicit, starttls: starttls}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	isTLS := false
+	if s.implicit {
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+		isTLS = true
+	}
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ready")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(line[len(cmd):])
+
+		switch cmd {
+		case "EHLO":
+			tp.PrintfLine("250-fake")
+			if s.starttls && !isTLS {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN LOGIN CRAM-MD5")
+		case "STARTTLS":
+			tp.PrintfLine("220 go ahead")
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+			tp = textproto.NewConn(conn)
+			isTLS = true
+		case "AUTH":
+			user := s.auth(tp, arg)
+			if user == "" {
+				tp.PrintfLine("535 bad auth")
+				continue
+			}
+			s.lock.Lock()
+			s.user = user
+			s.lock.Unlock()
+			tp.PrintfLine("235 ok")
+		case "MAIL":
+			s.lock.Lock()
+			s.from = arg[len("FROM:"):]
+			s.lock.Unlock()
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.lock.Lock()
+			s.rcpts = append(s.rcpts, strings.Trim(arg[len("TO:"):], "<>"))
+			s.lock.Unlock()
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			buf, _ := tp.ReadDotBytes()
+			s.lock.Lock()
+			s.tls = isTLS
+			s.data = string(buf)
+			s.lock.Unlock()
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unknown")
+		}
+	}
+}
+
+// returns the authenticated user
+func (s *fakeSMTP) auth(tp *textproto.Conn, arg string) string {
+
+	f := strings.Fields(arg)
+	ask := func(prompt string) string {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, _ := tp.ReadLine()
+		b, _ := base64.StdEncoding.DecodeString(line)
+		return string(b)
+	}
+
+	switch f[0] {
+	case "PLAIN":
+		b, _ := base64.StdEncoding.DecodeString(f[1])
+		p := strings.Split(string(b), "\x00")
+		if len(p) == 3 && p[2] == "secret" {
+			return p[1]
+		}
+	case "LOGIN":
+		user := ask("Username:")
+		if ask("Password:") == "secret" {
+			return user
+		}
+	case "CRAM-MD5":
+		chal := "<1234@fake>"
+		p := strings.Fields(ask(chal))
+		h := hmac.New(md5.New, []byte("secret"))
+		h.Write([]byte(chal))
+		if len(p) == 2 && p[1] == hex.EncodeToString(h.Sum(nil)) {
+			return p[0]
+		}
+	}
+	return ""
+}
+
+func TestSendMail(t *testing.T) {
+
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	cert := ts.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	tests := []struct {
+		mode     string
+		implicit bool
+		starttls bool
+		auth     string
+		verify   bool
+		tls      bool
+		err      string
+	}{
+		{mode: "", starttls: true, verify: true, tls: true},
+		{mode: "", starttls: false, auth: "login", tls: false},
+		{mode: "none", starttls: true, auth: "cram-md5", tls: false},
+		{mode: "starttls", starttls: false, err: "doesn't support STARTTLS"},
+		{mode: "starttls", starttls: true, auth: "plain", verify: true, tls: true},
+		{mode: "starttls", starttls: true, err: "certificate"},
+		{mode: "insecure_skip_verify", starttls: true, auth: "login", tls: true},
+		{mode: "implicit", implicit: true, auth: "cram-md5", verify: true, tls: true},
+	}
+
+	for _, test := range tests {
+		s := newFakeSMTP(t, cert, test.implicit, test.starttls)
+		srv := &SmtpServer{Hostname: "127.0.0.1", Username: "bob", Password: "secret", Auth: test.auth, TLS: test.mode}
+		auth, err := srv.auth()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tlsc := &tls.Config{ServerName: srv.Hostname, InsecureSkipVerify: test.mode == smtpTLSSkipVerify}
+		if test.verify {
+			tlsc.RootCAs = roots
+		}
+
+		err = sendMail(context.Background(), &module.NetPolicy{}, 5*time.Second, s.ln.Addr().String(), srv.Hostname, test.mode, tlsc, auth,
+			"alice@example.com", []string{"bob@example.com", "carol@example.com"}, []byte("Subject: hi\r\n\r\nhello\r\n"))
+
+		desc := fmt.Sprintf("%s/%s", test.mode, test.auth)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected %s, got %v", desc, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", desc, err)
+			continue
+		}
+
+		s.lock.Lock()
+		if s.tls != test.tls || s.user != "bob" || s.from != "<alice@example.com>" || strings.Join(s.rcpts, " ") != "bob@example.com carol@example.com" || !strings.Contains(s.data, "hello") {
+			t.Errorf("%s: tls %v, user %q, from %q, rcpts %v, data %q", desc, s.tls, s.user, s.from, s.rcpts, s.data)
+		}
+		s.lock.Unlock()
+	}
+
+	// a server that accepts, and then says nothing
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	t0 := time.Now()
+	err = sendMail(context.Background(), &module.NetPolicy{}, 100*time.Millisecond, ln.Addr().String(), "127.0.0.1", "none", nil, nil,
+		"alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\nhello\r\n"))
+	if err == nil || time.Since(t0) > 2*time.Second {
+		t.Errorf("stalled server: %v after %v", err, time.Since(t0))
+	}
+}
+
+func TestSmtpRecipients(t *testing.T) {
+
+	msg := &SmtpMsg{
+		To:     "bob@example.com",
+		ToName: "Bob",
+		Cc:     "Carol <carol@example.com>, dave@example.com",
+		Bcc:    []interface{}{"eve@example.com", map[string]interface{}{"address": "frank@example.com", "name": "Frank Z"}},
+	}
+
+	to, cc, bcc, err := msg.Recipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := joinAddrs(to); s != `"Bob" <bob@example.com>` {
+		t.Errorf("to %s", s)
+	}
+	if s := joinAddrs(cc); s != `"Carol" <carol@example.com>, <dave@example.com>` {
+		t.Errorf("cc %s", s)
+	}
+	if s := joinAddrs(bcc); s != `<eve@example.com>, "Frank Z" <frank@example.com>` {
+		t.Errorf("bcc %s", s)
+	}
+	if s := strings.Join(envelope(to, cc, bcc), " "); s != "bob@example.com carol@example.com dave@example.com eve@example.com frank@example.com" {
+		t.Errorf("envelope %s", s)
+	}
+	for from, exp := range map[string]string{"ops@example.com": "ops@example.com", "Ops <ops@example.com>": "ops@example.com", "not an address": "not an address"} {
+		if s := envelopeFrom(from); s != exp {
+			t.Errorf("envelope from %s: %s", from, s)
+		}
+	}
+
+	for _, bad := range []interface{}{"not an address", 42, []interface{}{map[string]interface{}{"name": "x"}}} {
+		if _, _, _, err := (&SmtpMsg{To: bad}).Recipients(); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+
+	if _, err := (&SmtpServer{Username: "x", Auth: "ntlm"}).auth(); err == nil {
+		t.Errorf("expected invalid auth")
+	}
+	if _, err := (&SmtpServer{TLS: "sometimes"}).auth(); err == nil {
+		t.Errorf("expected invalid tls")
+	}
+}
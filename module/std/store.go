@@ -0,0 +1,127 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 01:10 (EDT)
+// Function: remember things between runs
+
+package modstd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/dop251/goja"
+)
+
+// in js code:
+//
+//	var store = module('std/store')
+//	if( store.incr('pages/' + host, 1, {ttl: 3600000}) > 3 ) return   // at most 3 per hour
+//
+//	var r = store.get('oncall')
+//	...
+//	if( !store.cas('oncall', r.version, next).ok ) ...     // someone else changed it
+//
+// each tenant (Federation) has its own keys. see module.KV for the semantics
+
+var _ = module.Register("std/store", installStore)
+
+// exported to js:
+type modStore struct {
+	as module.MASer
+	kv *module.KV
+}
+
+type StoreOpts struct {
+	TTL int64 `json:"ttl"` // msec. 0 = never expires
+}
+
+type StoreResult struct {
+	Value   interface{} `json:"value"`
+	Found   bool        `json:"found"`
+	Version int64       `json:"version"`
+	Expires int64       `json:"expires"` // js units. 0 = never
+}
+
+type StoreCASResult struct {
+	OK      bool  `json:"ok"`
+	Version int64 `json:"version"`
+}
+
+func installStore(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
+	return &modStore{
+		as: aser,
+		kv: &module.KV{Store: aser.Store(), Bucket: aser.Federation()},
+	}
+}
+
+// store the value. returns the new version
+func (m *modStore) Put(key string, value interface{}, opts *StoreOpts) (int64, error) {
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("store.put: %v", err)
+	}
+
+	return m.kv.Put(key, buf, opts.ttl())
+}
+
+func (m *modStore) Get(key string) (*StoreResult, error) {
+
+	it, err := m.kv.Get(key)
+	if err != nil || it == nil {
+		return &StoreResult{}, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(it.Value, &value)
+	if err != nil {
+		return nil, fmt.Errorf("store.get: %v", err)
+	}
+
+	return &StoreResult{value, true, it.Version, it.Expires}, nil
+}
+
+// returns whether it existed
+func (m *modStore) Delete(key string) (bool, error) {
+	return m.kv.Delete(key)
+}
+
+// the keys starting with prefix, sorted
+func (m *modStore) List(prefix string) ([]string, error) {
+	return m.kv.List(prefix)
+}
+
+// add n (default 1). returns the new value
+func (m *modStore) Incr(key string, n goja.Value, opts *StoreOpts) (int64, error) {
+
+	var add int64 = 1
+	if n != nil && !goja.IsUndefined(n) && !goja.IsNull(n) {
+		add = n.ToInteger()
+	}
+
+	return m.kv.Incr(key, add, opts.ttl())
+}
+
+// store the value, if the version (from get) is current. version 0 = the key must not exist
+func (m *modStore) Cas(key string, version int64, value interface{}, opts *StoreOpts) (*StoreCASResult, error) {
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("store.cas: %v", err)
+	}
+
+	ok, ver, err := m.kv.CompareAndSwap(key, version, buf, opts.ttl())
+	if err != nil {
+		return nil, err
+	}
+	return &StoreCASResult{ok, ver}, nil
+}
+
+func (o *StoreOpts) ttl() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return time.Duration(o.TTL) * time.Millisecond
+}
@@ -21,13 +21,15 @@ import (
 var _ = module.Register("std/syslog", installSyslog)
 
 type modSyslog struct {
-	as module.MASer
+	as        module.MASer
+	SendAsync func(goja.FunctionCall) goja.Value `json:"send_async"`
 }
 
 func installSyslog(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
 	m := &modSyslog{
 		as: aser,
 	}
+	m.SendAsync = module.Async(aser, vm, m.Send)
 	return m
 }
 
@@ -64,11 +66,15 @@ func (m *modSyslog) Send(dst string, msg *Message) error {
 		return err
 	}
 
+	call := m.as.Call("std/syslog", "send", dst)
+	defer call.Done()
+
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return err
 	}
@@ -76,18 +82,36 @@ func (m *modSyslog) Send(dst string, msg *Message) error {
 	// for debugging
 	m.as.Diagf("sending syslog to: %s", dst)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			err = mk.Failure()
+			if err != nil {
+				call.Result(0, err)
+				m.as.NetIOErr()
+				return fmt.Errorf("cannot send syslog: %v", err)
+			}
+		}
 		return nil
 	}
 
 	sev, err := syslog.Severity(msg.Severity)
 	if err != nil {
+		call.Result(0, err)
 		return fmt.Errorf("invalid severity '%s'", msg.Severity)
 	}
 
+	// the dialer checks the address after dns, check the name first
+	err = m.as.NetPolicy().CheckAddr(addr)
+	if err != nil {
+		call.Result(0, err)
+		m.as.NetIOErr()
+		return fmt.Errorf("cannot send syslog: %v", err)
+	}
+
 	opts := []syslog.OptFunc{
 		syslog.WithDst(proto, addr),
 		syslog.WithTimeout(m.as.NetTimeout()),
-		syslog.WithDialer(&net.Dialer{Timeout: m.as.NetTimeout()}),
+		// the syslog package does not take a context
+		syslog.WithDialer(m.as.NetPolicy().Dialer(&net.Dialer{Timeout: m.as.NetTimeout(), Cancel: m.as.Context().Done()})),
 		syslog.WithHostname(msg.Hostname),
 		syslog.WithAppName(msg.AppName),
 		syslog.WithFacilityName(msg.Facility),
@@ -99,6 +123,7 @@ func (m *modSyslog) Send(dst string, msg *Message) error {
 	slog, err := syslog.New(opts...)
 
 	if err != nil {
+		call.Result(0, err)
 		return fmt.Errorf("cannot send syslog: %v", err)
 	}
 
@@ -108,6 +133,7 @@ func (m *modSyslog) Send(dst string, msg *Message) error {
 		Message: msg.Message,
 	})
 
+	call.Result(0, err)
 	return err
 }
 
@@ -40,7 +40,7 @@ func TestCEF(t *testing.T) {
 		},
 	}
 
-	p, err := m.CEF(c)
+	p, err := m.Cef(c)
 	if err != nil {
 		t.Fatalf("error: %v", err)
 	}
@@ -0,0 +1,223 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 02:20 (EDT)
+// Function: throttling, deduplication, and digests of alerts
+
+package modstd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/dop251/goja"
+)
+
+// in js code:
+//
+//	var throttle = module('std/throttle')
+//	if( !throttle.allow('page/' + host, {max: 3, per: 3600000}) ) return   // at most 3 pages per hour
+//	if( throttle.dedup.seen(event.id, 600000) ) return                      // already handled
+//
+//	var d = throttle.digest('disk/' + host, event, {per: 3600000})
+//	if( d.send ) {
+//	    // the first event in the window, plus a summary of the ones suppressed in the previous window
+//	    notify(event, d.suppressed, d.events)
+//	}
+//
+// state is kept in the store (Conf.Store), per tenant (Federation), separate from std/store.
+// dry runs keep their own state, so they do not suppress real alerts
+
+var _ = module.Register("std/throttle", installThrottle)
+
+// exported to js:
+type modThrottle struct {
+	as    module.MASer
+	kv    *module.KV
+	Dedup *throttleDedup `json:"dedup"`
+}
+
+type throttleDedup struct {
+	kv *module.KV
+}
+
+type ThrottleOpts struct {
+	Max int64 `json:"max"`
+	Per int64 `json:"per"` // msec
+}
+
+type DigestOpts struct {
+	Per  int64 `json:"per"`  // msec
+	Keep int   `json:"keep"` // how many suppressed events to keep. default 10
+}
+
+type DigestResult struct {
+	Send       bool          `json:"send"`       // send this one
+	Suppressed int64         `json:"suppressed"` // number suppressed in the previous window
+	Events     []interface{} `json:"events"`     // the first few of them
+	First      int64         `json:"first"`      // when the first + last were suppressed, js units
+	Last       int64         `json:"last"`
+}
+
+// what is kept in the store
+type digestState struct {
+	WindowEnd int64             `json:"window_end"` // unix msec
+	Count     int64             `json:"count"`
+	Events    []json.RawMessage `json:"events,omitempty"`
+	First     int64             `json:"first,omitempty"`
+	Last      int64             `json:"last,omitempty"`
+}
+
+const (
+	defaultDigestKeep = 10
+	// suppressed events are reported by the next event, if it arrives within this long after the window
+	digestLinger = 24 * time.Hour
+)
+
+func installThrottle(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
+
+	bkt := aser.Federation() + "\x00throttle"
+	if aser.IsDryRun() {
+		bkt += "\x00dryrun"
+	}
+	kv := &module.KV{Store: aser.Store(), Bucket: bkt}
+
+	return &modThrottle{
+		as:    aser,
+		kv:    kv,
+		Dedup: &throttleDedup{kv},
+	}
+}
+
+// is another event permitted? at most max per window
+func (m *modThrottle) Allow(key string, opts *ThrottleOpts) (bool, error) {
+
+	if opts == nil || opts.Per <= 0 || opts.Max <= 0 {
+		return false, fmt.Errorf("throttle.allow(key, {max, per})")
+	}
+
+	// the ttl starts the window when the counter is created
+	n, err := m.kv.Incr("allow/"+key, 1, time.Duration(opts.Per)*time.Millisecond)
+	if err != nil {
+		return false, err
+	}
+	return n <= opts.Max, nil
+}
+
+// has the key been seen in the window (msec)? the window starts at the first sighting
+func (d *throttleDedup) Seen(key string, window int64) (bool, error) {
+
+	if window <= 0 {
+		return false, fmt.Errorf("throttle.dedup.seen(key, window)")
+	}
+
+	ok, _, err := d.kv.CompareAndSwap("seen/"+key, 0, []byte("1"), time.Duration(window)*time.Millisecond)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// the first event in a window is sent, with a summary of those suppressed in the previous window.
+// the others, until the window ends, are suppressed
+func (m *modThrottle) Digest(key string, event interface{}, opts *DigestOpts) (*DigestResult, error) {
+
+	if opts == nil || opts.Per <= 0 {
+		return nil, fmt.Errorf("throttle.digest(key, event, {per})")
+	}
+	keep := opts.Keep
+	if keep <= 0 {
+		keep = defaultDigestKeep
+	}
+	ev, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("throttle.digest: %v", err)
+	}
+
+	return m.modifyDigest(key, opts.Per, func(st *digestState, now int64) *DigestResult {
+
+		if now >= st.WindowEnd {
+			res := st.summary()
+			*st = digestState{WindowEnd: now + opts.Per}
+			res.Send = true
+			return res
+		}
+
+		st.Count++
+		if len(st.Events) < keep {
+			st.Events = append(st.Events, ev)
+		}
+		if st.First == 0 {
+			st.First = now
+		}
+		st.Last = now
+		return &DigestResult{}
+	})
+}
+
+// the suppressed events, without waiting for the window to end.
+// send is true if there were any
+func (m *modThrottle) Flush(key string) (*DigestResult, error) {
+
+	return m.modifyDigest(key, 0, func(st *digestState, now int64) *DigestResult {
+
+		res := st.summary()
+		res.Send = st.Count != 0
+		*st = digestState{WindowEnd: st.WindowEnd}
+		return res
+	})
+}
+
+// atomically update the digest state
+func (m *modThrottle) modifyDigest(key string, per int64, f func(*digestState, int64) *DigestResult) (*DigestResult, error) {
+
+	key = "digest/" + key
+	var res *DigestResult
+
+	_, err := m.kv.Modify(key, func(value []byte) ([]byte, time.Duration, error) {
+
+		if per == 0 && value == nil {
+			// nothing to flush
+			res = &DigestResult{}
+			return nil, 0, nil
+		}
+
+		st := &digestState{}
+		if value != nil {
+			if err := json.Unmarshal(value, st); err != nil {
+				return nil, 0, fmt.Errorf("throttle: corrupt digest '%s': %v", key, err)
+			}
+		}
+
+		res = f(st, time.Now().UnixNano()/1e6)
+
+		buf, err := json.Marshal(st)
+		if err != nil {
+			return nil, 0, err
+		}
+		ttl := time.Until(time.Unix(0, st.WindowEnd*1e6)) + digestLinger
+		return buf, ttl, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (st *digestState) summary() *DigestResult {
+
+	res := &DigestResult{
+		Suppressed: st.Count,
+		First:      st.First,
+		Last:       st.Last,
+		Events:     []interface{}{},
+	}
+	for _, e := range st.Events {
+		var v interface{}
+		json.Unmarshal(e, &v)
+		res.Events = append(res.Events, v)
+	}
+	return res
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 23:10 (EDT)
+// Function: inspect tls certificates
+
+package modstd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+	"github.com/dop251/goja"
+	"golang.org/x/crypto/ocsp"
+)
+
+// in js code:
+//
+//	var tls = module('std/tls')
+//	var r = tls.inspect('www.example.com:443')
+//	if( r.error || !r.verified || r.days_left < 14 ) alert(...)
+
+var _ = module.Register("std/tls", installTLS)
+
+// exported to js:
+type modTLS struct {
+	as           module.MASer
+	InspectAsync func(goja.FunctionCall) goja.Value `json:"inspect_async"`
+}
+
+type TLSInspectOpts struct {
+	ServerName string `json:"server_name"` // for sni + verification. default is the host
+}
+
+type TLSInspectResult struct {
+	Addr               string     `json:"addr"`
+	Error              string     `json:"error"` // could not connect, or handshake failed
+	Version            string     `json:"version"`
+	CipherSuite        string     `json:"cipher_suite"`
+	NegotiatedProtocol string     `json:"negotiated_protocol"`
+	ServerName         string     `json:"server_name"`
+	Verified           bool       `json:"verified"` // against the system roots, for the server name
+	VerifyError        string     `json:"verify_error"`
+	DaysLeft           int        `json:"days_left"` // until the first certificate in the chain expires
+	NotAfter           int64      `json:"not_after"` // of the server's certificate, js units
+	SANs               []string   `json:"sans"`      // of the server's certificate
+	OCSPStapled        bool       `json:"ocsp_stapled"`
+	OCSPStatus         string     `json:"ocsp_status"` // good, revoked, unknown, or invalid
+	Chain              []*TLSCert `json:"chain"`       // as sent by the server, starting with its certificate
+}
+
+func installTLS(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
+	m := &modTLS{as: aser}
+	m.InspectAsync = module.Async(aser, vm, m.Inspect)
+	return m
+}
+
+// connect, and report on the server's certificates
+func (m *modTLS) Inspect(addr string, opts *TLSInspectOpts) (*TLSInspectResult, error) {
+
+	if addr == "" {
+		return nil, fmt.Errorf("tls.inspect(host:port)")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	if opts == nil {
+		opts = &TLSInspectOpts{}
+	}
+
+	call := m.as.Call("std/tls", "inspect", addr)
+	defer call.Done()
+
+	closer, err := m.as.NetIOHeavy()
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		call.Result(0, err)
+		m.as.Fatal(err)
+		return nil, err
+	}
+
+	m.as.Diagf("tls: inspect %s", addr)
+	if m.as.IsDryRun() {
+		res := &TLSInspectResult{Addr: addr}
+		if mk := m.as.Mock(call); mk != nil {
+			if err := mk.Err(); err != nil {
+				res.Error = err.Error()
+			} else if mk.Body != "" {
+				// the body is the result, as json
+				json.Unmarshal([]byte(mk.Body), res)
+			}
+		}
+		return res, nil
+	}
+
+	res, err := m.inspect(addr, opts)
+	if err != nil {
+		call.Result(500, err)
+		m.as.NetIOErr()
+		m.as.Logf("tls inspect failed: %v", err)
+		return &TLSInspectResult{Addr: addr, Error: err.Error()}, nil
+	}
+
+	call.Result(200, nil)
+	return res, nil
+}
+
+// inspect, or replay a previous inspection
+func (m *modTLS) inspect(addr string, opts *TLSInspectOpts) (*TLSInspectResult, error) {
+
+	cas := m.as.Cassette()
+	dest := "tls://" + addr
+
+	if cas.Replaying() {
+		it, err := cas.Replay(module.KindTLS, "INSPECT", dest)
+		if err != nil {
+			return nil, err
+		}
+		if it.Error != "" {
+			return nil, fmt.Errorf("%s", it.Error)
+		}
+		res := &TLSInspectResult{}
+		err = json.Unmarshal([]byte(it.Body), res)
+		return res, err
+	}
+
+	res, err := m.handshake(addr, opts)
+
+	if cas.Recording() {
+		it := &module.Interaction{Kind: module.KindTLS, Method: "INSPECT", URL: dest, Code: 200}
+		if err != nil {
+			it.Code = 500
+			it.Error = err.Error()
+		} else {
+			js, _ := json.Marshal(res)
+			it.Body = string(js)
+		}
+		cas.Record(it)
+	}
+
+	return res, err
+}
+
+func (m *modTLS) handshake(addr string, opts *TLSInspectOpts) (*TLSInspectResult, error) {
+
+	host, _, _ := net.SplitHostPort(addr)
+	name := opts.ServerName
+	if name == "" {
+		name = host
+	}
+
+	ctx, cancel := context.WithTimeout(m.as.Context(), m.as.NetTimeout())
+	defer cancel()
+
+	conn, err := m.as.NetPolicy().DialContext(ctx, &net.Dialer{}, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// verified below, so we can report on invalid certificates
+	tc := tls.Client(conn, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+	err = tc.HandshakeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return inspectState(addr, name, tc.ConnectionState(), time.Now()), nil
+}
+
+func inspectState(addr, name string, cs tls.ConnectionState, now time.Time) *TLSInspectResult {
+
+	res := &TLSInspectResult{
+		Addr:               addr,
+		Version:            tlsVersionName(cs.Version),
+		CipherSuite:        tls.CipherSuiteName(cs.CipherSuite),
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		ServerName:         name,
+		OCSPStapled:        len(cs.OCSPResponse) != 0,
+	}
+
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		res.VerifyError = "no certificates"
+		return res
+	}
+
+	for i, c := range certs {
+		ci := certInfo(c, now)
+		res.Chain = append(res.Chain, ci)
+		if i == 0 || ci.DaysLeft < res.DaysLeft {
+			res.DaysLeft = ci.DaysLeft
+		}
+	}
+
+	leaf := certs[0]
+	res.NotAfter = leaf.NotAfter.UnixNano() / 1e6
+	res.SANs = append(res.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		res.SANs = append(res.SANs, ip.String())
+	}
+
+	vo := x509.VerifyOptions{
+		DNSName:       name,
+		CurrentTime:   now,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range certs[1:] {
+		vo.Intermediates.AddCert(c)
+	}
+	chains, err := leaf.Verify(vo)
+	if err != nil {
+		res.VerifyError = err.Error()
+	} else {
+		res.Verified = true
+	}
+
+	if res.OCSPStapled {
+		res.OCSPStatus = ocspStatus(cs.OCSPResponse, leaf, certs, chains)
+	}
+
+	return res
+}
+
+func ocspStatus(staple []byte, leaf *x509.Certificate, certs []*x509.Certificate, chains [][]*x509.Certificate) string {
+
+	var issuer *x509.Certificate
+	switch {
+	case len(chains) != 0 && len(chains[0]) > 1:
+		issuer = chains[0][1]
+	case len(certs) > 1:
+		issuer = certs[1]
+	}
+
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return "invalid"
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func certInfo(c *x509.Certificate, now time.Time) *TLSCert {
+
+	fp := sha256.Sum256(c.Raw)
+
+	return &TLSCert{
+		Signature:             c.Signature,
+		SignatureAlgorithm:    c.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm:    c.PublicKeyAlgorithm.String(),
+		Version:               c.Version,
+		SerialNumber:          c.SerialNumber.String(),
+		Issuer:                c.Issuer,
+		Subject:               c.Subject,
+		KeyUsage:              int32(c.KeyUsage),
+		SubjectKeyId:          c.SubjectKeyId,
+		AuthorityKeyId:        c.AuthorityKeyId,
+		DNSNames:              c.DNSNames,
+		EmailAddresses:        c.EmailAddresses,
+		IPAddresses:           c.IPAddresses,
+		URIs:                  c.URIs,
+		CRLDistributionPoints: c.CRLDistributionPoints,
+		NotBefore:             c.NotBefore.UTC().UnixNano() / 1e6,
+		NotAfter:              c.NotAfter.UTC().UnixNano() / 1e6,
+		SubjectName:           c.Subject.String(),
+		IssuerName:            c.Issuer.String(),
+		DaysLeft:              int(math.Floor(c.NotAfter.Sub(now).Hours() / 24)),
+		Fingerprint:           hex.EncodeToString(fp[:]),
+	}
+}
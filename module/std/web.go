@@ -9,11 +9,17 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/jaw0/go-alertscript/module"
 	"github.com/dop251/goja"
@@ -23,11 +29,48 @@ var _ = module.Register("std/web", installWeb)
 
 // exported to js:
 type modWeb struct {
-	as       module.MASer
-	Get      goja.Value `json:"get"`
-	Post     goja.Value `json:"post"`
-	PostJSON goja.Value `json:"post_json"`
-	PostUE   goja.Value `json:"post_urlencoded"`
+	as            module.MASer
+	Get           goja.Value                         `json:"get"`
+	Post          goja.Value                         `json:"post"`
+	PostJSON      goja.Value                         `json:"post_json"`
+	PostUE        goja.Value                         `json:"post_urlencoded"`
+	RequestAsync  func(goja.FunctionCall) goja.Value `json:"request_async"`
+	GetAsync      goja.Value                         `json:"get_async"`
+	PostAsync     goja.Value                         `json:"post_async"`
+	PostJSONAsync goja.Value                         `json:"post_json_async"`
+	PostUEAsync   goja.Value                         `json:"post_urlencoded_async"`
+	Put           goja.Value                         `json:"put"`
+	Patch         goja.Value                         `json:"patch"`
+	Delete        goja.Value                         `json:"delete"`
+	Head          goja.Value                         `json:"head"`
+	PutAsync      goja.Value                         `json:"put_async"`
+	PatchAsync    goja.Value                         `json:"patch_async"`
+	DeleteAsync   goja.Value                         `json:"delete_async"`
+	HeadAsync     goja.Value                         `json:"head_async"`
+	FetchAsync    func(goja.FunctionCall) goja.Value `json:"fetch_async"`
+
+	PostMultipart      func(string, map[string][]string, *MultipartForm) (*WebResult, error) `json:"post_multipart"`
+	PostMultipartAsync func(goja.FunctionCall) goja.Value                                    `json:"post_multipart_async"`
+
+	DecodeJSON bool                `json:"decode_json"` // set by the script
+	Retry      *module.RetryPolicy `json:"retry"`       // set by the script
+	TLS        string              `json:"tls"`         // tls profile name, set by the script
+	OAuth2     string              `json:"oauth2"`      // oauth2 client name, set by the script
+}
+
+// the details of a request
+type webRequest struct {
+	url        string
+	method     string
+	hdrs       map[string][]string
+	body       string
+	timeout    time.Duration // if shorter than NetTimeout
+	noRedirect bool
+	decodeJSON bool
+	retry      *module.RetryPolicy
+	tls        string // profile name
+	sign       *SignOpts
+	oauth2     string // client name
 }
 
 // returned to user
@@ -36,7 +79,11 @@ type WebResult struct {
 	Message string              `json:"message"`
 	Body    string              `json:"body"`
 	Header  map[string][]string `json:"header"`
-	tls     *tls.ConnectionState
+	// if decoding is enabled, and the response is json
+	Data      interface{} `json:"data"`
+	JSONError string      `json:"json_error,omitempty"`
+	Truncated bool        `json:"truncated"` // the body exceeded the size limit
+	tls       *tls.ConnectionState
 }
 
 // QQQ - provide more / less?
@@ -51,7 +98,7 @@ type TLSResult struct {
 type TLSCert struct {
 	Signature             []byte     `json:"signature"`
 	SignatureAlgorithm    string     `json:"signature_alg"`
-	PublicKeyAlgorithm    string     `json:"signature_alg"`
+	PublicKeyAlgorithm    string     `json:"public_key_alg"`
 	Version               int        `json:"version"`
 	SerialNumber          string     `json:"serial_number"`
 	Issuer                pkix.Name  `json:"issuer"`
@@ -66,28 +113,82 @@ type TLSCert struct {
 	IPAddresses           []net.IP   `json:"ip_addresses"`
 	URIs                  []*url.URL `json:"uris"`
 	CRLDistributionPoints []string   `json:"crl_distribution_points"`
+	SubjectName           string     `json:"subject_name"` // eg. "CN=www.example.com,O=Example"
+	IssuerName            string     `json:"issuer_name"`
+	DaysLeft              int        `json:"days_left"`   // until expiry. negative if expired
+	Fingerprint           string     `json:"fingerprint"` // sha256, hex
 }
 
 func installWeb(aser module.MASer, vm *goja.Runtime, args []interface{}) interface{} {
-	wg, _ := vm.RunProgram(webGet)
-	wp, _ := vm.RunProgram(webPost)
-	wj, _ := vm.RunProgram(webPostJson)
-	wu, _ := vm.RunProgram(webPostUrlEnc)
-	m := &modWeb{aser, wg, wp, wj, wu}
+	m := &modWeb{as: aser}
+
+	m.Get = webWrapper(vm, webGet, "request")
+	m.Post = webWrapper(vm, webPost, "request")
+	m.PostJSON = webWrapper(vm, webPostJson, "request")
+	m.PostUE = webWrapper(vm, webPostUrlEnc, "request")
+	m.Put = webWrapper(vm, webVerb, "request", "PUT")
+	m.Patch = webWrapper(vm, webVerb, "request", "PATCH")
+	m.Delete = webWrapper(vm, webVerb, "request", "DELETE")
+	m.Head = webWrapper(vm, webVerb, "request", "HEAD")
+	m.PostMultipart = m.postMultipart
+
+	// promise returning versions
+	m.RequestAsync = module.AsyncWith(aser, vm, m.snapshot, (*modWeb).Request)
+	m.GetAsync = webWrapper(vm, webGet, "request_async")
+	m.PostAsync = webWrapper(vm, webPost, "request_async")
+	m.PostJSONAsync = webWrapper(vm, webPostJson, "request_async")
+	m.PostUEAsync = webWrapper(vm, webPostUrlEnc, "request_async")
+	m.PutAsync = webWrapper(vm, webVerb, "request_async", "PUT")
+	m.PatchAsync = webWrapper(vm, webVerb, "request_async", "PATCH")
+	m.DeleteAsync = webWrapper(vm, webVerb, "request_async", "DELETE")
+	m.HeadAsync = webWrapper(vm, webVerb, "request_async", "HEAD")
+	m.FetchAsync = module.AsyncWith(aser, vm, m.snapshot, (*modWeb).Fetch)
+	m.PostMultipartAsync = module.AsyncWith(aser, vm, m.snapshot, (*modWeb).postMultipart)
+
 	return m
 }
 
 func NewWeb(aser module.MASer) *modWeb {
-	return &modWeb{aser, nil, nil, nil, nil}
+	return &modWeb{as: aser}
+}
+
+// a copy of the settings (decode_json, retry, ...), for a request running in the background
+func (m *modWeb) snapshot() interface{} {
+	c := *m
+	c.Retry = m.Retry.Clone()
+	return &c
+}
+
+// build the js helper function, using either request or request_async
+func webWrapper(vm *goja.Runtime, prog *goja.Program, req string, args ...string) goja.Value {
+	mk, _ := vm.RunProgram(prog)
+	f, _ := goja.AssertFunction(mk)
+
+	jsargs := []goja.Value{vm.ToValue(req)}
+	for _, a := range args {
+		jsargs = append(jsargs, vm.ToValue(a))
+	}
+	w, _ := f(goja.Undefined(), jsargs...)
+	return w
 }
 
 func (m *modWeb) Request(url, method string, hdrs map[string][]string, content string) (*WebResult, error) {
+	return m.do(&webRequest{url: url, method: method, hdrs: hdrs, body: content})
+}
+
+func (m *modWeb) do(r *webRequest) (*WebResult, error) {
+
+	url, method, hdrs, content := r.url, r.method, r.hdrs, r.body
+
+	call := m.as.Call("std/web", method, url)
+	defer call.Done()
 
 	closer, err := m.as.NetIOHeavy()
 	if closer != nil {
 		defer closer()
 	}
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(err)
 		return nil, err
 	}
@@ -95,28 +196,105 @@ func (m *modWeb) Request(url, method string, hdrs map[string][]string, content s
 	// for debugging
 	m.as.Diagf("web: %s %s\nheaders: %+v\nbody: %s\n", method, url, hdrs, content)
 	if m.as.IsDryRun() {
+		if mk := m.as.Mock(call); mk != nil {
+			ret := m.mocked(call, mk)
+			m.decode(ret, r)
+			return ret, nil
+		}
 		return &WebResult{Code: 200, Message: "not tried"}, nil
 	}
 
 	// build request
-	client := &http.Client{Timeout: m.as.NetTimeout()}
-	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(content)))
+	client := m.as.HTTPClient()
+	tlsp := r.tls
+	if tlsp == "" {
+		tlsp = m.TLS
+	}
+	if tlsp != "" {
+		client, err = m.as.TLSClient(tlsp)
+		if err != nil {
+			call.Result(0, err)
+			m.as.Error(err)
+			return nil, err
+		}
+	}
+	if r.noRedirect {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	retry := r.retry
+	if retry == nil {
+		retry = m.Retry
+	}
+	ctx := module.WithTimeout(module.WithRetry(m.as.Context(), retry), r.timeout)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader([]byte(content)))
 	if err != nil {
+		call.Result(0, err)
 		m.as.Fatal(fmt.Errorf("webRequest: error %v", err))
 		return nil, err
 	}
 
-	req.Header = hdrs
+	if hdrs != nil {
+		req.Header = hdrs
+	}
+
+	// the client checks each connection, but not the scheme
+	err = m.as.NetPolicy().CheckURL(req.URL)
+	if err != nil {
+		call.Result(0, err)
+		m.as.NetIOErr()
+		m.as.Logf("Request Failed: %v", err)
+		return &WebResult{Code: 403, Message: "Forbidden", Body: err.Error()}, nil
+	}
+
+	oauth := r.oauth2
+	if oauth == "" {
+		oauth = m.OAuth2
+	}
+	if oauth != "" {
+		tok, err := oauth2Token(m.as, oauth)
+		var cerr *module.OAuth2ClientError
+		if errors.As(err, &cerr) {
+			call.Result(0, err)
+			m.as.Error(err)
+			return nil, err
+		}
+		if err != nil {
+			call.Result(0, err)
+			return &WebResult{Code: 500, Message: "Token Request Failed", Body: err.Error()}, nil
+		}
+		req.Header.Set("Authorization", tok.TokenType+" "+tok.AccessToken)
+	}
+
+	unsigned := req.Header.Clone()
+	err = signRequest(m.as, req, []byte(content), r.sign, time.Now())
+	if err != nil {
+		call.Result(0, err)
+		m.as.Error(err)
+		return nil, err
+	}
+	if r.sign != nil {
+		// the cassette does not record the signature
+		req = req.WithContext(module.WithRedactedHeaders(req.Context(), changedHeaders(unsigned, req.Header)...))
+	}
 
 	// send request
 	resp, err := client.Do(req)
 
 	if err != nil {
+		call.Result(500, err)
 		m.as.NetIOErr()
 		m.as.Logf("Request Failed: %v", err)
 		return &WebResult{Code: 500, Message: "Request Failed", Body: err.Error()}, nil
 	}
+	defer resp.Body.Close()
 
+	call.Result(resp.StatusCode, nil)
+	if oauth != "" && resp.StatusCode == 401 {
+		// get a new token next time
+		m.as.TokenCache().Invalidate(m.as.Federation(), oauth)
+	}
 	if resp.Status[0] != '2' {
 		m.as.NetIOErr()
 		m.as.Logf("Request Failed: %s", resp.Status)
@@ -124,13 +302,86 @@ func (m *modWeb) Request(url, method string, hdrs map[string][]string, content s
 		m.as.Diagf("%v", resp.Status)
 	}
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	ret := &WebResult{Code: resp.StatusCode, Message: resp.Status[4:], Body: string(body), Header: resp.Header, tls: resp.TLS}
+	// do not let a huge response use up all the memory
+	max := m.as.MaxResponseSize()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	ret := &WebResult{Code: resp.StatusCode, Message: resp.Status[4:], Header: resp.Header, tls: resp.TLS}
+
+	if int64(len(body)) > max {
+		body = body[:max]
+		ret.Truncated = true
+		m.as.Logf("web: response truncated to %d bytes", max)
+	}
+	ret.Body = string(body)
+	m.decode(ret, r)
 
 	return ret, nil
 
 }
 
+// simulate the response, for a dry run
+func (m *modWeb) mocked(call *module.Call, mk *module.Mock) *WebResult {
+
+	if err := mk.Err(); err != nil {
+		call.Result(500, err)
+		m.as.NetIOErr()
+		m.as.Logf("Request Failed: %v", err)
+		return &WebResult{Code: 500, Message: "Request Failed", Body: err.Error()}
+	}
+
+	call.Result(mk.StatusCode(), nil)
+	if mk.StatusCode() >= 300 {
+		m.as.NetIOErr()
+		m.as.Logf("Request Failed: %d %s", mk.StatusCode(), mk.Status())
+	}
+
+	return &WebResult{Code: mk.StatusCode(), Message: mk.Status(), Body: mk.Body, Header: mk.Header}
+}
+
+// automatically decode json responses, if enabled
+func (m *modWeb) decode(wr *WebResult, r *webRequest) {
+
+	if !m.DecodeJSON && !r.decodeJSON {
+		return
+	}
+	if !isJSON(wr.Header) {
+		return
+	}
+
+	v, err := wr.Json()
+	if err != nil {
+		wr.JSONError = err.Error()
+		m.as.Diagf("web: %v", err)
+		return
+	}
+	wr.Data = v
+}
+
+// in js: r.json() - throws if the body is not valid json
+func (wr *WebResult) Json() (interface{}, error) {
+
+	if wr.Truncated {
+		return nil, fmt.Errorf("response is not valid json: body was truncated")
+	}
+
+	var v interface{}
+	err := json.Unmarshal([]byte(wr.Body), &v)
+	if err != nil {
+		return nil, fmt.Errorf("response is not valid json: %v", err)
+	}
+	return v, nil
+}
+
+func isJSON(hdrs map[string][]string) bool {
+
+	ct := http.Header(hdrs).Get("Content-Type")
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
 // get the tls details from the result
 func (wr *WebResult) Tls() *TLSResult {
 	tls := wr.tls
@@ -152,53 +403,38 @@ func (wr *WebResult) Tls() *TLSResult {
 func (wr *WebResult) Cert(n int) *TLSCert {
 	tls := wr.tls
 
-	if tls == nil || n >= len(tls.PeerCertificates) {
+	if tls == nil || n < 0 || n >= len(tls.PeerCertificates) {
 		return nil
 	}
 
-	c := tls.PeerCertificates[n]
-
-	return &TLSCert{
-		Signature:             c.Signature,
-		SignatureAlgorithm:    c.SignatureAlgorithm.String(),
-		PublicKeyAlgorithm:    c.PublicKeyAlgorithm.String(),
-		Version:               c.Version,
-		SerialNumber:          c.SerialNumber.String(),
-		Issuer:                c.Issuer,
-		Subject:               c.Subject,
-		KeyUsage:              int32(c.KeyUsage),
-		SubjectKeyId:          c.SubjectKeyId,
-		AuthorityKeyId:        c.AuthorityKeyId,
-		DNSNames:              c.DNSNames,
-		EmailAddresses:        c.EmailAddresses,
-		IPAddresses:           c.IPAddresses,
-		URIs:                  c.URIs,
-		CRLDistributionPoints: c.CRLDistributionPoints,
-		NotBefore:             c.NotBefore.UTC().UnixNano() / 1e6,
-		NotAfter:              c.NotAfter.UTC().UnixNano() / 1e6,
-	}
-	return nil
+	return certInfo(tls.PeerCertificates[n], time.Now())
 }
 
-var webGet = goja.MustCompile("runtime", `(function(url){ return this.request(url, 'GET') })`, false)
+var webGet = goja.MustCompile("runtime", `
+(function(req){ return function(url){ return this[req](url, 'GET') } })`, false)
 var webPost = goja.MustCompile("runtime", `
-(function(url, hdrs, body){ return this.request(url, 'POST', hdrs, body) })`, false)
+(function(req){ return function(url, hdrs, body){ return this[req](url, 'POST', hdrs, body) } })`, false)
+
+var webVerb = goja.MustCompile("runtime", `
+(function(req, method){ return function(url, hdrs, body){ return this[req](url, method, hdrs, body) } })`, false)
 
 var webPostJson = goja.MustCompile("runtime", `
-     (function(url, hdrs, data){
+   (function(req){
+     return function(url, hdrs, data){
  	if( !hdrs ) hdrs = {}
          hdrs['Content-Type'] = ['application/json']
          var body = JSON.stringify( data )
-         return this.request(url, 'POST', hdrs, body)
-     })`, false)
+         return this[req](url, 'POST', hdrs, body)
+     }})`, false)
 
 var webPostUrlEnc = goja.MustCompile("runtime", `
-     (function(url, hdrs, data){
+   (function(req){
+     return function(url, hdrs, data){
  	if( !hdrs ) hdrs = {}
          hdrs['Content-Type'] = ['application/x-www-form-urlencoded']
          var k, args=[]
          for (k in data){
              args.push(encodeURIComponent(k) + "=" + encodeURIComponent(data[k]))
          }
-         return this.request(url, 'POST', hdrs, args.join("&"))
-     })`, false)
+         return this[req](url, 'POST', hdrs, args.join("&"))
+     }})`, false)
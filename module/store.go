@@ -0,0 +1,273 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 00:50 (EDT)
+// Function: key-value storage for std/store
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// where std/store keeps its data, so scripts can remember things between runs.
+// provided by the host (Conf.Store), see std/memstore + std/filestore.
+// each tenant (Federation) gets its own bucket.
+// implementations must be safe for concurrent use, and Update must be atomic
+// across everything using the same data - each run has its own KV, and a host
+// may create a Store per run - or KV.Incr + KV.CompareAndSwap are not atomic.
+//
+// a store only holds bytes, the semantics (versions, expiry, counters)
+// are implemented once, by KV, on top of these. storetest.Run checks an implementation
+type Store interface {
+	Get(bucket, key string) ([]byte, bool, error)
+	// atomically replace the value with f(current value). if f returns nil, the key is deleted.
+	// if f returns an error, nothing is changed, and the error is returned
+	Update(bucket, key string, f func(value []byte, found bool) ([]byte, error)) error
+	// keys with the prefix, sorted
+	List(bucket, prefix string) ([]string, error)
+}
+
+// the operations of std/store, for one bucket:
+//
+//   - every write gives the item a new, larger, version. versions come from the clock,
+//     so a key that is deleted and written again does not reuse an old version
+//   - an item with a ttl expires after that long, and then behaves as if deleted
+//   - incr treats a missing item as 0. the ttl is only applied when it creates the item
+//   - compare-and-swap writes only if the current version matches. version 0 = the key must not exist
+//
+// incr, compare-and-swap, and modify are atomic as long as the Store's Update is
+type KV struct {
+	Store  Store
+	Bucket string
+	Now    func() time.Time // for testing. default time.Now
+}
+
+// what is actually stored
+type KVItem struct {
+	Value   json.RawMessage `json:"value"`
+	Version int64           `json:"version"`
+	Expires int64           `json:"expires,omitempty"` // unix msec. 0 = never
+}
+
+// the value is not a number
+type KVNotNumberError struct {
+	Key string
+}
+
+func (e *KVNotNumberError) Error() string {
+	return fmt.Sprintf("store: value of '%s' is not a number", e.Key)
+}
+
+// the item, or nil if it does not exist or has expired
+func (kv *KV) Get(key string) (*KVItem, error) {
+
+	buf, found, err := kv.Store.Get(kv.Bucket, key)
+	if err != nil || !found {
+		return nil, err
+	}
+	return kv.decode(key, buf)
+}
+
+// store the value. returns the new version
+func (kv *KV) Put(key string, value []byte, ttl time.Duration) (int64, error) {
+
+	var version int64
+
+	err := kv.Store.Update(kv.Bucket, key, func(buf []byte, found bool) ([]byte, error) {
+		old, _ := kv.decodeAny(buf, found)
+		it := kv.newItem(old, value, ttl)
+		version = it.Version
+		return json.Marshal(it)
+	})
+
+	return version, err
+}
+
+// returns whether the item existed (and had not expired)
+func (kv *KV) Delete(key string) (bool, error) {
+
+	existed := false
+
+	err := kv.Store.Update(kv.Bucket, key, func(buf []byte, found bool) ([]byte, error) {
+		it, _ := kv.decodeAny(buf, found)
+		existed = it != nil && !kv.expired(it)
+		return nil, nil
+	})
+
+	return existed, err
+}
+
+// the keys with the prefix, sorted. expired items are not included
+func (kv *KV) List(prefix string) ([]string, error) {
+
+	keys, err := kv.Store.List(kv.Bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []string
+	for _, k := range keys {
+		it, err := kv.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		if it != nil {
+			live = append(live, k)
+		}
+	}
+	return live, nil
+}
+
+// add n to the (integer) value. returns the new value
+func (kv *KV) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+
+	var value int64
+
+	err := kv.Store.Update(kv.Bucket, key, func(buf []byte, found bool) ([]byte, error) {
+		old, err := kv.decodeAny(buf, found)
+		if err != nil {
+			return nil, err
+		}
+
+		var cur int64
+		if old != nil && !kv.expired(old) {
+			cur, err = strconv.ParseInt(string(old.Value), 10, 64)
+			if err != nil {
+				return nil, &KVNotNumberError{key}
+			}
+		}
+		value = cur + n
+
+		it := kv.newItem(old, []byte(strconv.FormatInt(value, 10)), ttl)
+		if old != nil && !kv.expired(old) {
+			// keep the existing expiry
+			it.Expires = old.Expires
+		}
+		return json.Marshal(it)
+	})
+
+	return value, err
+}
+
+// write the value if the current version matches. returns whether it did, and the new version
+func (kv *KV) CompareAndSwap(key string, version int64, value []byte, ttl time.Duration) (bool, int64, error) {
+
+	var ok bool
+	var nver int64
+
+	err := kv.Store.Update(kv.Bucket, key, func(buf []byte, found bool) ([]byte, error) {
+		old, _ := kv.decodeAny(buf, found)
+
+		var cur int64
+		if old != nil && !kv.expired(old) {
+			cur = old.Version
+		}
+		if cur != version {
+			// leave it as is
+			return buf, nil
+		}
+
+		it := kv.newItem(old, value, ttl)
+		ok, nver = true, it.Version
+		return json.Marshal(it)
+	})
+
+	if err != nil {
+		return false, 0, err
+	}
+	return ok, nver, nil
+}
+
+// replace the value with f(current value, nil if none). f also returns the ttl.
+// if f returns a nil value, nothing is changed. if it returns an error, that is returned.
+// for read-modify-write that cannot be expressed with incr, without retrying cas
+func (kv *KV) Modify(key string, f func(value []byte) ([]byte, time.Duration, error)) (int64, error) {
+
+	var version int64
+
+	err := kv.Store.Update(kv.Bucket, key, func(buf []byte, found bool) ([]byte, error) {
+		old, err := kv.decodeAny(buf, found)
+		if err != nil {
+			return nil, err
+		}
+
+		var cur []byte
+		if old != nil && !kv.expired(old) {
+			cur = old.Value
+		}
+		value, ttl, err := f(cur)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			// leave it as is
+			return buf, nil
+		}
+
+		it := kv.newItem(old, value, ttl)
+		version = it.Version
+		return json.Marshal(it)
+	})
+
+	return version, err
+}
+
+// ################################################################
+
+func (kv *KV) now() time.Time {
+	if kv.Now != nil {
+		return kv.Now()
+	}
+	return time.Now()
+}
+
+func (kv *KV) newItem(old *KVItem, value []byte, ttl time.Duration) *KVItem {
+
+	now := kv.now()
+
+	// msec would be too coarse, nsec too large for js
+	version := now.UnixNano() / 1e3
+	if old != nil && old.Version >= version {
+		version = old.Version + 1
+	}
+
+	it := &KVItem{Value: value, Version: version}
+	if ttl > 0 {
+		it.Expires = now.Add(ttl).UnixNano() / 1e6
+	}
+	return it
+}
+
+func (kv *KV) expired(it *KVItem) bool {
+	return it.Expires != 0 && kv.now().UnixNano()/1e6 >= it.Expires
+}
+
+// the live item, or nil
+func (kv *KV) decode(key string, buf []byte) (*KVItem, error) {
+
+	it := &KVItem{}
+	err := json.Unmarshal(buf, it)
+	if err != nil {
+		return nil, fmt.Errorf("store: corrupt item '%s': %v", key, err)
+	}
+	if kv.expired(it) {
+		return nil, nil
+	}
+	return it, nil
+}
+
+// the item, even if expired, so versions keep increasing
+func (kv *KV) decodeAny(buf []byte, found bool) (*KVItem, error) {
+	if !found {
+		return nil, nil
+	}
+	it := &KVItem{}
+	err := json.Unmarshal(buf, it)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-17 01:50 (EDT)
+// Function: conformance tests for module.Store implementations
+
+package storetest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// in an implementation's tests:
+//
+//	func TestStore(t *testing.T) {
+//	    storetest.Run(t, New(...))
+//	}
+func Run(t *testing.T, s module.Store) {
+	t.Run("primitives", func(t *testing.T) { primitives(t, s) })
+	t.Run("kv", func(t *testing.T) { kv(t, s) })
+}
+
+func primitives(t *testing.T, s module.Store) {
+
+	set := func(b, k, v string) {
+		err := s.Update(b, k, func([]byte, bool) ([]byte, error) { return []byte(v), nil })
+		if err != nil {
+			t.Fatalf("update %s/%s: %v", b, k, err)
+		}
+	}
+	get := func(b, k string) (string, bool) {
+		v, found, err := s.Get(b, k)
+		if err != nil {
+			t.Fatalf("get %s/%s: %v", b, k, err)
+		}
+		return string(v), found
+	}
+
+	if _, found := get("p", "x"); found {
+		t.Fatalf("found missing key")
+	}
+
+	set("p", "b/1", "one")
+	set("p", "a", "A")
+	set("p", "b/2", "two")
+	set("q", "b/3", "other bucket")
+
+	if v, found := get("p", "b/1"); !found || v != "one" {
+		t.Fatalf("get: %q %v", v, found)
+	}
+
+	// sees the current value
+	err := s.Update("p", "b/1", func(v []byte, found bool) ([]byte, error) {
+		if !found || string(v) != "one" {
+			t.Errorf("update saw %q %v", v, found)
+		}
+		return []byte("uno"), nil
+	})
+	if v, _ := get("p", "b/1"); err != nil || v != "uno" {
+		t.Fatalf("update: %q %v", v, err)
+	}
+
+	// errors change nothing
+	oops := errors.New("oops")
+	err = s.Update("p", "b/1", func([]byte, bool) ([]byte, error) { return []byte("no"), oops })
+	if v, _ := get("p", "b/1"); err != oops || v != "uno" {
+		t.Fatalf("update error: %q %v", v, err)
+	}
+
+	keys, err := s.List("p", "b/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"b/1", "b/2"}) {
+		t.Fatalf("list: %v %v", keys, err)
+	}
+
+	// nil deletes
+	s.Update("p", "b/1", func([]byte, bool) ([]byte, error) { return nil, nil })
+	if _, found := get("p", "b/1"); found {
+		t.Fatalf("not deleted")
+	}
+	keys, _ = s.List("p", "")
+	if !reflect.DeepEqual(keys, []string{"a", "b/2"}) {
+		t.Fatalf("list after delete: %v", keys)
+	}
+
+	keys, err = s.List("nonesuch", "")
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("list empty: %v %v", keys, err)
+	}
+}
+
+func kv(t *testing.T, s module.Store) {
+
+	now := time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC)
+	kv := &module.KV{Store: s, Bucket: "kv", Now: func() time.Time { return now }}
+
+	v1, err := kv.Put("k", []byte(`"a"`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, _ := kv.Put("k", []byte(`"b"`), 0)
+	it, _ := kv.Get("k")
+	if v2 <= v1 || it == nil || it.Version != v2 || string(it.Value) != `"b"` {
+		t.Fatalf("versions: %d %d %+v", v1, v2, it)
+	}
+
+	// ttl
+	kv.Put("t", []byte(`1`), time.Minute)
+	if it, _ := kv.Get("t"); it == nil {
+		t.Fatalf("expired early")
+	}
+	now = now.Add(time.Minute)
+	if it, _ := kv.Get("t"); it != nil {
+		t.Fatalf("did not expire")
+	}
+	if keys, _ := kv.List(""); !reflect.DeepEqual(keys, []string{"k"}) {
+		t.Fatalf("list: %v", keys)
+	}
+	if existed, _ := kv.Delete("t"); existed {
+		t.Fatalf("deleted expired item")
+	}
+
+	// delete
+	if existed, _ := kv.Delete("k"); !existed {
+		t.Fatalf("delete")
+	}
+	if it, _ := kv.Get("k"); it != nil {
+		t.Fatalf("not deleted")
+	}
+
+	// incr. the ttl applies when created
+	for i, exp := range []int64{2, 5, 4} {
+		n := []int64{2, 3, -1}[i]
+		r, err := kv.Incr("n", n, time.Minute)
+		if err != nil || r != exp {
+			t.Fatalf("incr: %d %v, expected %d", r, err, exp)
+		}
+		now = now.Add(20 * time.Second)
+	}
+	if it, _ := kv.Get("n"); it != nil {
+		t.Fatalf("counter did not expire")
+	}
+	if r, _ := kv.Incr("n", 1, 0); r != 1 {
+		t.Fatalf("incr after expiry: %d", r)
+	}
+	kv.Put("s", []byte(`"x"`), 0)
+	var nerr *module.KVNotNumberError
+	if _, err := kv.Incr("s", 1, 0); !errors.As(err, &nerr) {
+		t.Fatalf("incr string: %v", err)
+	}
+
+	// compare-and-swap
+	ok, ver, err := kv.CompareAndSwap("c", 0, []byte(`1`), 0)
+	if !ok || err != nil {
+		t.Fatalf("cas create: %v %v", ok, err)
+	}
+	if ok, _, _ := kv.CompareAndSwap("c", 0, []byte(`2`), 0); ok {
+		t.Fatalf("cas create existing")
+	}
+	if ok, _, _ := kv.CompareAndSwap("c", ver+1, []byte(`2`), 0); ok {
+		t.Fatalf("cas wrong version")
+	}
+	ok, ver2, _ := kv.CompareAndSwap("c", ver, []byte(`3`), 0)
+	it, _ = kv.Get("c")
+	if !ok || ver2 <= ver || it.Version != ver2 || string(it.Value) != `3` {
+		t.Fatalf("cas: %v %d %+v", ok, ver2, it)
+	}
+
+	// modify
+	add := func(v []byte) ([]byte, time.Duration, error) {
+		return append([]byte(`1`), v...), time.Minute, nil
+	}
+	kv.Modify("m", add)
+	ver, _ = kv.Modify("m", add)
+	if it, _ := kv.Get("m"); it == nil || it.Version != ver || string(it.Value) != `11` {
+		t.Fatalf("modify: %d %+v", ver, it)
+	}
+	kv.Modify("m", func(v []byte) ([]byte, time.Duration, error) { return nil, 0, nil })
+	if it, _ := kv.Get("m"); it == nil || string(it.Value) != `11` {
+		t.Fatalf("modify changed: %+v", it)
+	}
+	if _, err := kv.Modify("m", func(v []byte) ([]byte, time.Duration, error) { return nil, 0, errors.New("no") }); err == nil {
+		t.Fatalf("modify error not returned")
+	}
+	now = now.Add(time.Minute)
+	if _, err := kv.Modify("m", add); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := kv.Get("m"); it == nil || string(it.Value) != `1` {
+		t.Fatalf("modify after expiry: %+v", it)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 22:30 (EDT)
+// Function: named client tls configurations
+
+package module
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// tls profiles are configured by the host, and selected by name from js,
+// so keys and certificates never appear in the script:
+//
+//	web.fetch(url, {tls: 'partner'})
+//	web.tls = 'partner'             // for all requests from web
+//
+// pem data may be given inline, or read from a file
+type TLSProfile struct {
+	CAFile     string   // trusted CAs. default is the system roots
+	CAPEM      string   //
+	CertFile   string   // client certificate, for mtls
+	CertPEM    string   //
+	KeyFile    string   // client private key
+	KeyPEM     string   //
+	MinVersion string   // "1.2", "1.3". default 1.2
+	ServerName string   // override the name used to verify the server
+	Pins       []string // sha256 of the server's SubjectPublicKeyInfo, base64 or hex, "sha256/" optional.
+	SkipVerify bool     // do not verify the server certificate chain. only sensible with pins
+
+	once      sync.Once
+	config    *tls.Config
+	err       error
+	lock      sync.Mutex
+	transport *http.Transport // without a policy. with one, it is kept on the policy
+}
+
+type TLSProfiles map[string]*TLSProfile
+
+// the requested tls profile is not configured
+type TLSProfileError struct {
+	Name string
+}
+
+func (e *TLSProfileError) Error() string {
+	return fmt.Sprintf("tls profile not found: '%s'", e.Name)
+}
+
+// the server's certificate did not match any pin
+type PinError struct {
+	Host string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("certificate for %s does not match any pin", e.Host)
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (ps TLSProfiles) Get(name string) (*TLSProfile, error) {
+	p, ok := ps[name]
+	if !ok || p == nil {
+		return nil, &TLSProfileError{name}
+	}
+	return p, nil
+}
+
+// the tls config, built once
+func (p *TLSProfile) Config() (*tls.Config, error) {
+	p.once.Do(func() {
+		p.config, p.err = p.build()
+	})
+	return p.config, p.err
+}
+
+// a transport using this profile, that checks every connection against the policy.
+// shared, so connections can be reused. a policy made per run does not
+// leave a transport behind on the profile
+func (p *TLSProfile) Transport(pol *NetPolicy) (http.RoundTripper, error) {
+
+	cf, err := p.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if pol != nil {
+		return pol.profileTransport(p, cf), nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.transport == nil {
+		p.transport = pol.TLSTransport(cf)
+	}
+	return p.transport, nil
+}
+
+// ################################################################
+
+func (p *TLSProfile) build() (*tls.Config, error) {
+
+	min, ok := tlsVersions[p.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid tls version '%s'", p.MinVersion)
+	}
+
+	cf := &tls.Config{
+		MinVersion:         min,
+		ServerName:         p.ServerName,
+		InsecureSkipVerify: p.SkipVerify,
+	}
+
+	ca, err := pemData(p.CAPEM, p.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if ca != nil {
+		cf.RootCAs = x509.NewCertPool()
+		if !cf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid ca certificates")
+		}
+	}
+
+	cert, err := pemData(p.CertPEM, p.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pemData(p.KeyPEM, p.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil || key != nil {
+		c, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		cf.Certificates = []tls.Certificate{c}
+	}
+
+	if len(p.Pins) != 0 {
+		pins, err := parsePins(p.Pins)
+		if err != nil {
+			return nil, err
+		}
+		cf.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPins(cs, pins)
+		}
+	}
+
+	return cf, nil
+}
+
+func pemData(pem, file string) ([]byte, error) {
+	if pem != "" {
+		return []byte(pem), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(file)
+}
+
+func parsePins(list []string) ([][]byte, error) {
+	var pins [][]byte
+
+	for _, s := range list {
+		s = strings.TrimPrefix(s, "sha256/")
+
+		pin, err := hex.DecodeString(s)
+		if err != nil {
+			pin, err = base64.StdEncoding.DecodeString(s)
+		}
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin '%s'", s)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// any certificate in the chain may match
+func checkPins(cs tls.ConnectionState, pins [][]byte) error {
+
+	for _, cert := range cs.PeerCertificates {
+		h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(h[:], pin) {
+				return nil
+			}
+		}
+	}
+	return &PinError{cs.ServerName}
+}
+
+// the pin for a certificate, as used in TLSProfile.Pins
+func CertPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 22:50 (EDT)
+// Function: tls profile tests
+
+package module
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSProfile(t *testing.T) {
+
+	certPEM, keyPEM, cert := testCert(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(cert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	pin := CertPin(srv.Certificate())
+
+	get := func(p *TLSProfile) (string, error) {
+		rt, err := p.Transport(nil)
+		if err != nil {
+			return "", err
+		}
+		c := &http.Client{Transport: rt, Timeout: 5 * time.Second}
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 100)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	body, err := get(&TLSProfile{CAPEM: ca, CertPEM: certPEM, KeyPEM: keyPEM, Pins: []string{pin}, MinVersion: "1.3"})
+	if err != nil || body != "hello tester" {
+		t.Fatalf("mtls: %q %v", body, err)
+	}
+
+	// no client cert
+	_, err = get(&TLSProfile{CAPEM: ca})
+	if err == nil {
+		t.Fatalf("expected failure without client cert")
+	}
+
+	// wrong pin
+	_, err = get(&TLSProfile{CAPEM: ca, CertPEM: certPEM, KeyPEM: keyPEM, Pins: []string{CertPin(cert)}})
+	var perr *PinError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected pin error: %v", err)
+	}
+
+	// pin only
+	_, err = get(&TLSProfile{CertPEM: certPEM, KeyPEM: keyPEM, Pins: []string{pin}, SkipVerify: true})
+	if err != nil {
+		t.Fatalf("pin only: %v", err)
+	}
+
+	// shared per policy, and kept on the policy, not the profile
+	p := &TLSProfile{CAPEM: ca}
+	pol1, pol2 := &NetPolicy{}, &NetPolicy{}
+	t1, _ := p.Transport(pol1)
+	t1b, _ := p.Transport(pol1)
+	t2, _ := p.Transport(pol2)
+	if t1 != t1b || t1 == t2 {
+		t.Errorf("transports not shared per policy")
+	}
+	if p.transport != nil || len(pol1.tlsTrans) != 1 {
+		t.Errorf("transport kept on the profile")
+	}
+	t0, _ := p.Transport(nil)
+	if t0b, _ := p.Transport(nil); t0 != t0b {
+		t.Errorf("transport without policy not shared")
+	}
+
+	for _, p := range []*TLSProfile{
+		{MinVersion: "1.4"},
+		{CAPEM: "junk"},
+		{CertPEM: certPEM},
+		{Pins: []string{"sha256/abcd"}},
+	} {
+		if _, err := p.Config(); err == nil {
+			t.Errorf("expected error for %+v", p)
+		}
+	}
+
+	_, err = TLSProfiles{}.Get("nope")
+	var nerr *TLSProfileError
+	if !errors.As(err, &nerr) {
+		t.Errorf("expected not found: %v", err)
+	}
+}
+
+// a self signed client certificate
+func testCert(t *testing.T) (string, string, *x509.Certificate) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tester"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})),
+		cert
+}
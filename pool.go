@@ -0,0 +1,163 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 11:20 (EDT)
+// Function: pool of reusable js runtimes
+
+package alertscript
+
+import (
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// Pool keeps initialized runtimes for reuse. the globals are reset after
+// every run. changes to the built-in objects (eg. Array.prototype) cannot
+// be undone, so a runtime is only ever reused for the same Federation,
+// and nothing a script does can leak to another tenant.
+//
+// a Result from a pooled run belongs to the runtime; export it
+// before running the next script
+type Pool struct {
+	lock sync.Mutex
+	max  int
+	idle int
+	free map[string][]*jsRuntime
+}
+
+type jsRuntime struct {
+	vm        *goja.Runtime
+	m         *mAS
+	base      map[string]goja.Value // globals after setup
+	stringify goja.Callable         // the original JSON.stringify
+	ownNames  goja.Callable         // the original Object.getOwnPropertyNames
+	fed       string
+	dirty     bool // cannot be reset
+}
+
+// NewPool creates a pool that keeps at most max idle runtimes
+func NewPool(max int) *Pool {
+	return &Pool{
+		max:  max,
+		free: make(map[string][]*jsRuntime),
+	}
+}
+
+// a nil pool creates a new runtime every time
+func (p *Pool) get(fed string) *jsRuntime {
+
+	if p != nil {
+		p.lock.Lock()
+		l := p.free[fed]
+		if n := len(l); n > 0 {
+			rt := l[n-1]
+			l[n-1] = nil
+			if n == 1 {
+				delete(p.free, fed)
+			} else {
+				p.free[fed] = l[:n-1]
+			}
+			p.idle--
+			p.lock.Unlock()
+			return rt
+		}
+		p.lock.Unlock()
+	}
+
+	rt := newJsRuntime()
+	rt.fed = fed
+	return rt
+}
+
+func (p *Pool) put(rt *jsRuntime) {
+
+	if p == nil || rt.dirty || !rt.reset() {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.idle >= p.max {
+		return
+	}
+	p.free[rt.fed] = append(p.free[rt.fed], rt)
+	p.idle++
+}
+
+// number of idle runtimes
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.idle
+}
+
+// ################################################################
+
+// remember the initial set of globals
+func (rt *jsRuntime) snapshot() {
+	g := rt.vm.GlobalObject()
+	rt.base = make(map[string]goja.Value)
+	// a script may replace it
+	rt.ownNames, _ = goja.AssertFunction(rt.vm.Get("Object").ToObject(rt.vm).Get("getOwnPropertyNames"))
+
+	names, _ := rt.globalNames()
+	for _, k := range names {
+		rt.base[k] = g.Get(k)
+	}
+}
+
+// remove + restore any globals the script changed.
+// returns false if the runtime cannot be cleaned and should be discarded
+func (rt *jsRuntime) reset() (ok bool) {
+	g := rt.vm.GlobalObject()
+	rt.m.as = nil
+
+	defer func() {
+		if x := recover(); x != nil {
+			ok = false
+		}
+	}()
+
+	names, err := rt.globalNames()
+	if err != nil {
+		return false
+	}
+
+	for _, k := range names {
+		if _, ok := rt.base[k]; ok {
+			continue
+		}
+		if g.Delete(k) != nil {
+			// declared with var, not deletable
+			if g.Set(k, goja.Undefined()) != nil {
+				return false
+			}
+		}
+	}
+
+	for k, v := range rt.base {
+		if g.Get(k).SameAs(v) {
+			continue
+		}
+		if g.Set(k, v) != nil {
+			return false
+		}
+	}
+
+	rt.vm.ClearInterrupt()
+	return true
+}
+
+// names of all of the own properties of the global object, including the non-enumerable
+func (rt *jsRuntime) globalNames() ([]string, error) {
+	var names []string
+
+	v, err := rt.ownNames(goja.Undefined(), rt.vm.GlobalObject())
+	if err != nil {
+		return nil, err
+	}
+
+	err = rt.vm.ExportTo(v, &names)
+	return names, err
+}
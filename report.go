@@ -0,0 +1,122 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 16:44 (EDT)
+// Function: what happened during the run
+
+package alertscript
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/jaw0/go-alertscript/module"
+)
+
+// how the script ended
+const (
+	TermOK          = "ok"
+	TermError       = "error"        // exception, or module error
+	TermTimeout     = "timeout"      // Timeout exceeded
+	TermHardTimeout = "hard_timeout" // HardTimeout exceeded
+	TermCancelled   = "cancelled"    // by the caller's context
+	TermQuota       = "quota"        // too many network requests
+	TermHeapGrowth  = "heap_growth"  // MaxHeapGrowth exceeded
+	TermStack       = "stack"        // MaxCallStackSize exceeded
+)
+
+// an execution log of the run, suitable for showing to the user
+type Report struct {
+	Script      string          `json:"script"` // hash
+	Federation  string          `json:"federation,omitempty"`
+	Start       time.Time       `json:"start"`
+	Elapsed     time.Duration   `json:"elapsed"`
+	RunTime     time.Duration   `json:"run_time"` // not counting network time
+	NetTime     time.Duration   `json:"net_time"`
+	NetReqs     int             `json:"net_reqs"`
+	LocalReqs   int             `json:"local_reqs"`
+	NetErrs     int             `json:"net_errs"`
+	DryRun      bool            `json:"dry_run"`
+	Calls       []*module.Call  `json:"calls"`
+	Termination string          `json:"termination"`
+	Error       string          `json:"error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+}
+
+// the report for the completed run
+func (as *AS) Report() *Report {
+	return as.report
+}
+
+// start recording a network call
+func (m mAS) Call(mod, method, dest string) *module.Call {
+	c := module.NewCall(mod, method, dest, m.as.cf.NetMock)
+
+	m.as.lock.Lock()
+	defer m.as.lock.Unlock()
+	m.as.calls = append(m.as.calls, c)
+	return c
+}
+
+func (as *AS) buildReport(s *Script, err error) *Report {
+
+	r := &Report{
+		Script:      s.Hash(),
+		Federation:  as.cf.Federation,
+		Start:       as.t0,
+		NetTime:     as.NetTime,
+		NetReqs:     as.NetReqs,
+		LocalReqs:   as.LocalReqs,
+		NetErrs:     as.NetErrs,
+		DryRun:      as.cf.NetMock,
+		Calls:       as.calls,
+		Termination: TermOK,
+	}
+
+	if !as.t0.IsZero() {
+		r.Elapsed = as.tend.Sub(as.t0)
+		r.RunTime = as.tacc + as.tend.Sub(as.t1)
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+		r.Termination = as.reason
+
+		var serr *StackOverflowError
+		switch {
+		case r.Termination != "":
+		case errors.As(err, &serr):
+			r.Termination = TermStack
+		default:
+			r.Termination = TermError
+		}
+	} else {
+		r.Result = as.result
+	}
+
+	return r
+}
+
+// the result, as json. run while the timeout still applies, a toJSON may loop forever.
+// uses the original JSON.stringify, the script may have replaced it
+func (as *AS) exportResult(stringify goja.Callable, v goja.Value) (js json.RawMessage, err error) {
+
+	if v == nil || goja.IsUndefined(v) {
+		return nil, nil
+	}
+
+	defer func() {
+		if x := recover(); x != nil {
+			js, err = nil, fmt.Errorf("cannot export result: %v", x)
+		}
+	}()
+
+	res, err := stringify(goja.Undefined(), v)
+	if err != nil || res == nil || goja.IsUndefined(res) {
+		return nil, err
+	}
+
+	return json.RawMessage(res.String()), nil
+}
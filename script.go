@@ -0,0 +1,148 @@
+// Copyright (c) 2026
+// Author: Jeff Weisberg <tcp4me.com!jaw>
+// Created: 2026-Oct-16 09:42 (EDT)
+// Function: compile once, run many
+
+package alertscript
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+)
+
+// a compiled script. safe to run concurrently
+type Script struct {
+	prog    *goja.Program
+	hash    [sha256.Size]byte
+	lexical bool // has top level let/const/class
+}
+
+const defaultCacheSize = 1000
+
+// recently compiled scripts, keyed by content hash
+type progCache struct {
+	lock  sync.Mutex
+	max   int
+	lru   *list.List
+	items map[[sha256.Size]byte]*list.Element
+}
+
+var scriptCache = newProgCache(defaultCacheSize)
+
+// Compile parses + compiles the script (or finds it in the cache).
+// use it to check scripts for errors before saving them
+func Compile(src string) (*Script, error) {
+	return compile("", src)
+}
+
+// the name is used in error messages and stack traces
+func compile(name, src string) (*Script, error) {
+
+	var h [sha256.Size]byte
+	if name == "" {
+		h = sha256.Sum256([]byte(src))
+	} else {
+		h = sha256.Sum256([]byte(name + "\x00" + src))
+	}
+
+	if s := scriptCache.get(h); s != nil {
+		return s, nil
+	}
+
+	tree, err := parser.ParseFile(nil, name, src, 0)
+	if err != nil {
+		return nil, compileError(name, err)
+	}
+	prog, err := goja.CompileAST(tree, false)
+	if err != nil {
+		return nil, compileError(name, err)
+	}
+
+	s := &Script{prog: prog, hash: h, lexical: hasLexical(tree)}
+	scriptCache.add(s)
+	return s, nil
+}
+
+// content hash (hex) of the script source
+func (s *Script) Hash() string {
+	return hex.EncodeToString(s.hash[:])
+}
+
+// top level let/const/class declarations stay in the runtime,
+// and cannot be removed
+func hasLexical(tree *ast.Program) bool {
+	for _, st := range tree.Body {
+		switch st.(type) {
+		case *ast.LexicalDeclaration, *ast.ClassDeclaration:
+			return true
+		}
+	}
+	return false
+}
+
+// SetCacheSize changes the maximum number of compiled scripts kept. 0 disables caching
+func SetCacheSize(n int) {
+	scriptCache.resize(n)
+}
+
+// ################################################################
+
+func newProgCache(max int) *progCache {
+	return &progCache{
+		max:   max,
+		lru:   list.New(),
+		items: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+func (c *progCache) get(h [sha256.Size]byte) *Script {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[h]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*Script)
+}
+
+func (c *progCache) add(s *Script) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.max <= 0 {
+		return
+	}
+	if e, ok := c.items[s.hash]; ok {
+		// compiled concurrently by someone else
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.items[s.hash] = c.lru.PushFront(s)
+	c.trim()
+}
+
+func (c *progCache) resize(max int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.max = max
+	c.trim()
+}
+
+// remove the least recently used entries
+func (c *progCache) trim() {
+	for c.lru.Len() > c.max && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.items, e.Value.(*Script).hash)
+	}
+}